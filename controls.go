@@ -0,0 +1,283 @@
+package synth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// controlTableSizes defines the control tables of a subzone with their number of categories.
+// The categories follow the columns and rows of the age household and work household count tables.
+// These are fixed by the classification of the seed households. A schema sums finer census
+// breaks into them with several ControlColumns and splits coarser ones with a ControlBreak.
+var controlTableSizes = map[string]int{
+	"AgeHouseholdCol":  7,
+	"AgeHouseholdRow":  7,
+	"WorkHouseholdCol": 5,
+	"WorkHouseholdRow": 5,
+}
+
+// subzoneFields are the Subzone fields that are read from a single column
+var subzoneFields = []string{"Id", "Prov", "Sted", "Bevolking", "Huishoudens"}
+
+// ControlColumn maps a column of the subzones file to a category of a control table.
+// When several columns map to the same category, their values are added, such that
+// census releases with finer category breaks can be used.
+type ControlColumn struct {
+	Table    string
+	Category int
+	Column   string
+}
+
+// ControlBreak maps a column of the subzones file to the categories First through Last
+// of a control table, for census releases with coarser category breaks. Its total is
+// split over these categories in proportion to the seed households of the subzone.
+type ControlBreak struct {
+	Table  string
+	First  int
+	Last   int
+	Column string
+}
+
+// ControlSchema describes the columns of a subzones file
+type ControlSchema struct {
+	Fields  map[string]string // The column for each of the subzoneFields
+	Columns []ControlColumn
+	Breaks  []ControlBreak
+}
+
+// DefaultControlSchema is the schema of the 2013 subzones file
+var DefaultControlSchema = &ControlSchema{
+	Fields: map[string]string{
+		"Id":          "subzone",
+		"Prov":        "Prov",
+		"Sted":        "Sted",
+		"Bevolking":   "Bevolking",
+		"Huishoudens": "Huishoudens",
+	},
+	Columns: []ControlColumn{
+		{"AgeHouseholdCol", 0, "ma 0-34"},
+		{"AgeHouseholdCol", 1, "ma 35-54"},
+		{"AgeHouseholdCol", 2, "ma 55-64"},
+		{"AgeHouseholdCol", 3, "ma 65-74"},
+		{"AgeHouseholdCol", 4, "ma 75+"},
+		{"AgeHouseholdCol", 5, "vr ind"},
+		{"AgeHouseholdCol", 6, "vr liv"},
+		{"AgeHouseholdRow", 0, "vr 0-34"},
+		{"AgeHouseholdRow", 1, "vr 35-54"},
+		{"AgeHouseholdRow", 2, "vr 55-64"},
+		{"AgeHouseholdRow", 3, "vr 65-74"},
+		{"AgeHouseholdRow", 4, "vr 75+"},
+		{"AgeHouseholdRow", 5, "ma ind"},
+		{"AgeHouseholdRow", 6, "ma liv"},
+		{"WorkHouseholdCol", 0, "ma nt"},
+		{"WorkHouseholdCol", 1, "ma pt"},
+		{"WorkHouseholdCol", 2, "ma ft"},
+		{"WorkHouseholdCol", 3, "vr ind"},
+		{"WorkHouseholdCol", 4, "vr liv"},
+		{"WorkHouseholdRow", 0, "vr nt"},
+		{"WorkHouseholdRow", 1, "vr pt"},
+		{"WorkHouseholdRow", 2, "vr ft"},
+		{"WorkHouseholdRow", 3, "ma ind"},
+		{"WorkHouseholdRow", 4, "ma liv"},
+	},
+}
+
+// ReadControlSchema loads a schema from a tab separated file with a header and the
+// columns Table, Category and Column. The subzone fields are given with Table
+// "Subzone" and the field name as Category, for example "Subzone	Id	subzone".
+// A ControlBreak is given with the range of categories it covers as Category, for
+// example "AgeHouseholdCol	2-4	ma 55+".
+func ReadControlSchema(filename string) *ControlSchema {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer f.Close()
+
+	schema, err := ParseControlSchema(f)
+	if err != nil {
+		log.Panicln("Error reading control schema:", err)
+	}
+	return schema
+}
+
+// ParseControlSchema parses a schema in the format of ReadControlSchema
+func ParseControlSchema(in io.Reader) (*ControlSchema, error) {
+	schema := &ControlSchema{Fields: make(map[string]string)}
+
+	r := csv.NewReader(in)
+	r.Comma = '\t'
+	r.FieldsPerRecord = 3
+	if _, err := r.Read(); err != nil { // header
+		return nil, err
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		table, category, column := strings.TrimSpace(record[0]), strings.TrimSpace(record[1]), strings.TrimSpace(record[2])
+		if table == "Subzone" {
+			schema.Fields[category] = column
+			continue
+		}
+
+		if first, last, isBreak := strings.Cut(category, "-"); isBreak {
+			i, err1 := strconv.Atoi(strings.TrimSpace(first))
+			j, err2 := strconv.Atoi(strings.TrimSpace(last))
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid category range %q for control table %s", category, table)
+			}
+			schema.Breaks = append(schema.Breaks, ControlBreak{table, i, j, column})
+			continue
+		}
+
+		i, err := strconv.Atoi(category)
+		if err != nil {
+			return nil, fmt.Errorf("invalid category %q for control table %s", category, table)
+		}
+		schema.Columns = append(schema.Columns, ControlColumn{table, i, column})
+	}
+	return schema, nil
+}
+
+// validate checks that the schema maps every subzone field and every category of every control table
+func (schema *ControlSchema) validate() (errs []error) {
+	for _, field := range subzoneFields {
+		if schema.Fields[field] == "" {
+			errs = append(errs, fmt.Errorf("control schema has no column for subzone field %s", field))
+		}
+	}
+
+	mapped := make(map[string][]bool)
+	for table, size := range controlTableSizes {
+		mapped[table] = make([]bool, size)
+	}
+	for _, c := range schema.Columns {
+		if _, exists := mapped[c.Table]; !exists {
+			errs = append(errs, fmt.Errorf("control schema has unknown control table %s", c.Table))
+			continue
+		}
+		if c.Category < 0 || c.Category >= len(mapped[c.Table]) {
+			errs = append(errs, fmt.Errorf("control schema has category %d out of range for control table %s", c.Category, c.Table))
+			continue
+		}
+		mapped[c.Table][c.Category] = true
+	}
+	for _, b := range schema.Breaks {
+		if _, exists := mapped[b.Table]; !exists {
+			errs = append(errs, fmt.Errorf("control schema has unknown control table %s", b.Table))
+			continue
+		}
+		if b.First < 0 || b.Last >= len(mapped[b.Table]) || b.First > b.Last {
+			errs = append(errs, fmt.Errorf("control schema has category range %d-%d out of range for control table %s", b.First, b.Last, b.Table))
+			continue
+		}
+		for i := b.First; i <= b.Last; i++ {
+			mapped[b.Table][i] = true
+		}
+	}
+	for table, categories := range mapped {
+		for i, m := range categories {
+			if !m {
+				errs = append(errs, fmt.Errorf("control schema has no column for category %d of control table %s", i, table))
+			}
+		}
+	}
+	return
+}
+
+// columns returns all columns of the subzones file used by the schema
+func (schema *ControlSchema) columns() (columns []string) {
+	seen := make(map[string]bool)
+	add := func(c string) {
+		if !seen[c] {
+			seen[c] = true
+			columns = append(columns, c)
+		}
+	}
+	for _, field := range subzoneFields {
+		add(schema.Fields[field])
+	}
+	for _, c := range schema.Columns {
+		add(c.Column)
+	}
+	for _, b := range schema.Breaks {
+		add(b.Column)
+	}
+	return
+}
+
+// schemaPositions is a schema applied to the header of a subzones file
+type schemaPositions struct {
+	fields  map[string]int
+	columns []int
+	breaks  []int
+	schema  *ControlSchema
+}
+
+// positions finds the positions of the columns of the schema in the header
+func (schema *ControlSchema) positions(header []string) *schemaPositions {
+	find := func(column string) int {
+		i := columnIndex(header, column)
+		if i == -1 {
+			log.Panicf("Subzones file does not have column %q", column)
+		}
+		return i
+	}
+
+	p := &schemaPositions{fields: make(map[string]int), schema: schema}
+	for _, field := range subzoneFields {
+		p.fields[field] = find(schema.Fields[field])
+	}
+	for _, c := range schema.Columns {
+		p.columns = append(p.columns, find(c.Column))
+	}
+	for _, b := range schema.Breaks {
+		p.breaks = append(p.breaks, find(b.Column))
+	}
+	return p
+}
+
+// subzone creates a subzone from a record of the subzones file
+func (p *schemaPositions) subzone(record []string) *Subzone {
+	field := func(name string) int {
+		v, err := strconv.Atoi(strings.TrimSpace(record[p.fields[name]]))
+		if err != nil {
+			log.Panicln("Invalid value for subzone field", name, ":", err)
+		}
+		return v
+	}
+
+	value := func(column string, position int) float64 {
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[position]), 64)
+		if err != nil {
+			log.Panicln("Invalid value in column", column, "of subzone", record[p.fields["Id"]], ":", err)
+		}
+		return v
+	}
+
+	s := &Subzone{
+		Id:          field("Id"),
+		Prov:        field("Prov"),
+		Sted:        field("Sted"),
+		Bevolking:   field("Bevolking"),
+		Huishoudens: field("Huishoudens"),
+	}
+	for i, c := range p.schema.Columns {
+		*s.totalFields(c.Table)[c.Category] += value(c.Column, p.columns[i])
+	}
+	for i, b := range p.schema.Breaks {
+		s.breaks = append(s.breaks, breakTotal{b, value(b.Column, p.breaks[i])})
+	}
+	return s
+}
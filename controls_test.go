@@ -0,0 +1,77 @@
+package synth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestControlSchemaBreaks(t *testing.T) {
+	schema, err := ParseControlSchema(strings.NewReader(`Table	Category	Column
+Subzone	Id	subzone
+Subzone	Prov	Prov
+Subzone	Sted	Sted
+Subzone	Bevolking	Bevolking
+Subzone	Huishoudens	Huishoudens
+AgeHouseholdCol	0	ma 0-34
+AgeHouseholdCol	1	ma 35-54
+AgeHouseholdCol	2-4	ma 55+
+AgeHouseholdCol	5	vr ind
+AgeHouseholdCol	6	vr liv
+AgeHouseholdRow	0-6	vr
+WorkHouseholdCol	0-4	ma
+WorkHouseholdRow	0	vr nt
+WorkHouseholdRow	1	vr pt a
+WorkHouseholdRow	1	vr pt b
+WorkHouseholdRow	2	vr ft
+WorkHouseholdRow	3	ma ind
+WorkHouseholdRow	4	ma liv
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := schema.validate(); errs != nil {
+		t.Fatal(errs)
+	}
+
+	header := strings.Split("SUBZONE\tprov\tsted\tbevolking\thuishoudens\tma 0-34\tma 35-54\tma 55+\tvr ind\tvr liv\tvr\tma\tvr nt\tvr pt a\tvr pt b\tvr ft\tma ind\tma liv", "\t")
+	record := strings.Split("7\t3\t1\t200\t100\t10\t20\t60\t5\t5\t100\t100\t10\t15\t15\t40\t15\t5", "\t")
+	s := schema.positions(header).subzone(record)
+	if s.Id != 7 || s.Prov != 3 || s.Sted != 1 || s.Bevolking != 200 || s.Huishoudens != 100 {
+		t.Errorf("subzone fields are %+v", *s)
+	}
+
+	s.splitBreaks("AgeHouseholdCol", []float64{1, 1, 1, 2, 0, 1, 1})
+	s.splitBreaks("AgeHouseholdRow", []float64{0, 0, 0, 0, 0, 0, 0})
+	tests := []struct {
+		table string
+		want  []float64
+	}{
+		{"AgeHouseholdCol", []float64{10, 20, 20, 40, 0, 5, 5}},                                                     // The break by the seed
+		{"AgeHouseholdRow", []float64{100.0 / 7, 100.0 / 7, 100.0 / 7, 100.0 / 7, 100.0 / 7, 100.0 / 7, 100.0 / 7}}, // Evenly without seed
+		{"WorkHouseholdCol", []float64{0, 0, 0, 0, 0}},                                                              // Not split yet
+		{"WorkHouseholdRow", []float64{10, 30, 40, 15, 5}},                                                          // Finer breaks are summed
+	}
+	for _, test := range tests {
+		got := s.Totals(test.table)
+		for i := range test.want {
+			if d := got[i] - test.want[i]; d > 1e-9 || d < -1e-9 {
+				t.Errorf("%s totals are %v, want %v", test.table, got, test.want)
+				break
+			}
+		}
+	}
+	if s.AgeHouseholdColTotal4 != 40 {
+		t.Errorf("AgeHouseholdColTotal4 is %v, want 40", s.AgeHouseholdColTotal4)
+	}
+	if len(s.breaks) != 1 {
+		t.Errorf("%d breaks are left, want the WorkHouseholdCol break", len(s.breaks))
+	}
+}
+
+func TestControlSchemaBreakOutOfRange(t *testing.T) {
+	schema := &ControlSchema{Fields: DefaultControlSchema.Fields, Columns: DefaultControlSchema.Columns}
+	schema.Breaks = []ControlBreak{{"AgeHouseholdCol", 5, 7, "vr"}}
+	if errs := schema.validate(); len(errs) != 1 {
+		t.Errorf("got errors %v, want one for the category range", errs)
+	}
+}
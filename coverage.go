@@ -0,0 +1,95 @@
+package synth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+)
+
+// SkippedSubzone is a subzone for which no households were synthesized, with its
+// number of households and persons. A subzone for which only some households were
+// synthesized is a SkippedSubzone with the number of missing households.
+type SkippedSubzone struct {
+	Subzone    int
+	Reason     string
+	Households int
+	Persons    int
+}
+
+// coverageReport keeps track of the subzones that were skipped during a synthesis run
+type coverageReport struct {
+	skipped    []SkippedSubzone
+	households int // Households in all subzones
+	persons    int // Persons in all subzones
+}
+
+// add counts the households and persons of a subzone in the totals
+func (r *coverageReport) add(subzone *Subzone) {
+	r.households += subzone.Huishoudens
+	r.persons += subzone.Bevolking
+}
+
+// skip records that the subzone was skipped for the given reason
+func (r *coverageReport) skip(subzone *Subzone, reason string) {
+	r.skipped = append(r.skipped, SkippedSubzone{subzone.Id, reason, subzone.Huishoudens, subzone.Bevolking})
+}
+
+// shortfall records that the given number of households of the subzone could not be
+// synthesized, because their cell of the fitted table has no seed households
+func (r *coverageReport) shortfall(subzone *Subzone, households int) {
+	if households > 0 {
+		r.skipped = append(r.skipped, SkippedSubzone{subzone.Id, "no seed households", households, 0})
+	}
+}
+
+// coverage returns the fraction of the households that were not skipped
+func (r *coverageReport) coverage() float64 {
+	if r.households == 0 {
+		return 0
+	}
+	skipped := 0
+	for _, s := range r.skipped {
+		skipped += s.Households
+	}
+	return 1 - float64(skipped)/float64(r.households)
+}
+
+// finish logs a summary and writes the skipped subzones to filename if it is given. It
+// returns an error when the report cannot be written or the coverage is below minCoverage.
+func (r *coverageReport) finish(filename string, minCoverage float64) error {
+	skippedHouseholds, skippedPersons := 0, 0
+	for _, s := range r.skipped {
+		skippedHouseholds += s.Households
+		skippedPersons += s.Persons
+	}
+	log.Printf("Skipped %d subzones or parts with %d of %d households and %d of %d persons, coverage %.2f%%",
+		len(r.skipped), skippedHouseholds, r.households, skippedPersons, r.persons, r.coverage()*100)
+
+	if filename != "" {
+		if err := r.write(filename); err != nil {
+			return fmt.Errorf("writing coverage report: %v", err)
+		}
+	}
+
+	if minCoverage > 0 && r.coverage() < minCoverage {
+		return fmt.Errorf("coverage %.2f%% is below the minimal coverage of %.2f%%", r.coverage()*100, minCoverage*100)
+	}
+	return nil
+}
+
+// write writes the skipped subzones to a csv file
+func (r *coverageReport) write(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"Subzone", "Reason", "Households", "Persons"})
+	for _, s := range r.skipped {
+		w.Write([]string{d(s.Subzone), s.Reason, d(s.Households), d(s.Persons)})
+	}
+	return flushCsv(w, f)
+}
@@ -0,0 +1,35 @@
+package synth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCoverageFinish(t *testing.T) {
+	r := new(coverageReport)
+	for id := 1; id <= 4; id++ {
+		r.add(&Subzone{Id: id, Huishoudens: 10, Bevolking: 20})
+	}
+	r.skip(&Subzone{Id: 2, Huishoudens: 10, Bevolking: 20}, "no zipcodes")
+
+	filename := filepath.Join(t.TempDir(), "coverage.csv")
+	if err := r.finish(filename, 0.75); err != nil {
+		t.Errorf("coverage of 75%% with a minimum of 75%% failed: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Subzone,Reason,Households,Persons\n2,no zipcodes,10,20\n"; string(data) != want {
+		t.Errorf("coverage report is %q, want %q", data, want)
+	}
+
+	if err := r.finish("", 0.8); err == nil || !strings.Contains(err.Error(), "below the minimal coverage") {
+		t.Errorf("coverage of 75%% with a minimum of 80%% gave error %v", err)
+	}
+	if err := r.finish(filepath.Join(t.TempDir(), "missing", "coverage.csv"), 0); err == nil {
+		t.Errorf("writing to a missing directory gave no error")
+	}
+}
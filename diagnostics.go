@@ -0,0 +1,180 @@
+package synth
+
+import (
+	"encoding/csv"
+	"log"
+	"math"
+	"os"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// fitTables are the names of the compared tables in the order they are reported
+var fitTables = []string{"AgeHouseholdCol", "AgeHouseholdRow", "WorkHouseholdCol", "WorkHouseholdRow", "Households", "Persons"}
+
+// subzoneFit aggregates the synthesized households of a subzone such that they
+// can be compared to the control totals of that subzone.
+type subzoneFit struct {
+	subzone  *Subzone
+	observed map[string][]float64
+}
+
+// FitStatistics compares an aggregated table of synthesized households to its control totals
+type FitStatistics struct {
+	Subzone  int
+	Table    string
+	Observed float64 // Total of the synthesized households
+	Target   float64 // Total of the control totals
+	TAE      float64 // Total absolute error
+	SRMSE    float64 // Standardized root mean square error
+	Diff     float64 // Percentage deviation of the totals
+}
+
+// fitReport collects the subzone fits of a synthesis run
+type fitReport struct {
+	fits []*subzoneFit
+}
+
+// newSubzone adds and returns the fit for a subzone. A nil report returns a nil fit.
+func (r *fitReport) newSubzone(subzone *Subzone) *subzoneFit {
+	if r == nil {
+		return nil
+	}
+	f := &subzoneFit{subzone: subzone, observed: map[string][]float64{
+		"AgeHouseholdCol":  make([]float64, 7),
+		"AgeHouseholdRow":  make([]float64, 7),
+		"WorkHouseholdCol": make([]float64, 5),
+		"WorkHouseholdRow": make([]float64, 5),
+		"Households":       make([]float64, 1),
+		"Persons":          make([]float64, 1),
+	}}
+	r.fits = append(r.fits, f)
+	return f
+}
+
+// add adds a synthesized household to the fit. The heads of a household are
+// counted once, other members are counted as living in. A nil fit ignores the household.
+func (f *subzoneFit) add(hh *model.Household) {
+	if f == nil {
+		return
+	}
+
+	f.observed["Households"][0]++
+	f.observed["Persons"][0] += float64(len(hh.Member))
+
+	headCounted := false
+	for i, m := range classifyHousehold(hh) {
+		if hh.Member[i].Head {
+			if headCounted {
+				continue
+			}
+			headCounted = true
+		}
+		f.observed["AgeHouseholdCol"][m.AgeHousehold.U]++
+		f.observed["AgeHouseholdRow"][m.AgeHousehold.V]++
+		f.observed["WorkHouseholdCol"][m.WorkHousehold.U]++
+		f.observed["WorkHouseholdRow"][m.WorkHousehold.V]++
+	}
+}
+
+// targets returns the control totals of the subzone for the given table
+func (f *subzoneFit) targets(table string) []float64 {
+	switch table {
+	case "AgeHouseholdCol":
+		return f.subzone.AgeHouseholdColTotals()
+	case "AgeHouseholdRow":
+		return f.subzone.AgeHouseholdRowTotals()
+	case "WorkHouseholdCol":
+		return f.subzone.WorkHouseholdColTotals()
+	case "WorkHouseholdRow":
+		return f.subzone.WorkHouseholdRowTotals()
+	case "Households":
+		return []float64{float64(f.subzone.Huishoudens)}
+	case "Persons":
+		return []float64{float64(f.subzone.Bevolking)}
+	}
+	log.Panicln("Unknown fit table", table)
+	return nil
+}
+
+// compare returns the fit statistics of an observed table against its targets
+func compare(observed, targets []float64) (s FitStatistics) {
+	squares := 0.0
+	for i := range targets {
+		s.Observed += observed[i]
+		s.Target += targets[i]
+		s.TAE += math.Abs(observed[i] - targets[i])
+		squares += (observed[i] - targets[i]) * (observed[i] - targets[i])
+	}
+
+	n := float64(len(targets))
+	if s.Target != 0 {
+		s.SRMSE = math.Sqrt(squares/n) / (s.Target / n)
+	} else if s.Observed != 0 {
+		s.SRMSE = math.Inf(1)
+	}
+	s.Diff = relativeDiff(s.Observed, s.Target)
+	return
+}
+
+// relativeDiff returns the difference of observed and target in percent of the target.
+// It is 0 when both are 0 and infinite when only the target is 0.
+func relativeDiff(observed, target float64) float64 {
+	if target != 0 {
+		return (observed - target) / target * 100
+	}
+	if observed != 0 {
+		return math.Inf(1)
+	}
+	return 0
+}
+
+// statistics returns the fit statistics of every table of every subzone
+func (r *fitReport) statistics() (stats []FitStatistics) {
+	for _, f := range r.fits {
+		for _, table := range fitTables {
+			s := compare(f.observed[table], f.targets(table))
+			s.Subzone = f.subzone.Id
+			s.Table = table
+			stats = append(stats, s)
+		}
+	}
+	return
+}
+
+// write writes the fit statistics per subzone and table to a csv file and logs
+// a summary per table over all subzones.
+func (r *fitReport) write(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"Subzone", "Table", "Observed", "Target", "TAE", "SRMSE", "Diff"})
+
+	summary := make(map[string]*FitStatistics)
+	for _, table := range fitTables {
+		summary[table] = &FitStatistics{Table: table}
+	}
+	for _, st := range r.statistics() {
+		w.Write([]string{d(st.Subzone), st.Table, s(st.Observed), s(st.Target), s(st.TAE), s(st.SRMSE), s(st.Diff)})
+
+		t := summary[st.Table]
+		t.Observed += st.Observed
+		t.Target += st.Target
+		t.TAE += st.TAE
+		if !math.IsInf(st.SRMSE, 0) {
+			t.SRMSE += st.SRMSE / float64(len(r.fits))
+		}
+	}
+
+	log.Printf("Fit of %d subzones:", len(r.fits))
+	for _, table := range fitTables {
+		t := summary[table]
+		log.Printf("%-16s observed %10.0f target %10.0f TAE %10.0f mean SRMSE %.3f diff %.2f%%",
+			table, t.Observed, t.Target, t.TAE, t.SRMSE, relativeDiff(t.Observed, t.Target))
+	}
+	return flushCsv(w, f)
+}
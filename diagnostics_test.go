@@ -0,0 +1,23 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompareZeroTarget(t *testing.T) {
+	tests := []struct {
+		observed, targets []float64
+		srmse, diff       float64
+	}{
+		{[]float64{2, 2}, []float64{1, 3}, math.Sqrt(1) / 2, 0},
+		{[]float64{0, 0}, []float64{0, 0}, 0, 0},
+		{[]float64{1, 0}, []float64{0, 0}, math.Inf(1), math.Inf(1)},
+	}
+	for _, test := range tests {
+		s := compare(test.observed, test.targets)
+		if s.SRMSE != test.srmse || s.Diff != test.diff {
+			t.Errorf("compare(%v, %v) has SRMSE %v and diff %v, want %v and %v", test.observed, test.targets, s.SRMSE, s.Diff, test.srmse, test.diff)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package synth
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// HouseholdFilter selects households. Empty fields select all households and a
+// household must match every non-empty field.
+type HouseholdFilter struct {
+	Prov    []int
+	Urb     []model.Urb
+	Comp    []model.Comp
+	HomeMin model.Location // Lowest home zipcode, 0 for no lower bound
+	HomeMax model.Location // Highest home zipcode, 0 for no upper bound
+	Func    func(*model.Household) bool
+}
+
+// Match reports whether the household is selected by the filter. A nil filter selects all households.
+func (f *HouseholdFilter) Match(hh *model.Household) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Prov) > 0 {
+		found := false
+		for _, p := range f.Prov {
+			found = found || p == hh.Prov
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Urb) > 0 {
+		found := false
+		for _, u := range f.Urb {
+			found = found || u == hh.Urb
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Comp) > 0 {
+		found := false
+		for _, c := range f.Comp {
+			found = found || c == hh.Comp
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.HomeMin != 0 && hh.Home < f.HomeMin {
+		return false
+	}
+	if f.HomeMax != 0 && hh.Home > f.HomeMax {
+		return false
+	}
+	return f.Func == nil || f.Func(hh)
+}
+
+// sampled reports whether the household is in a sample of the given fraction. The
+// decision is a hash of the seed and the household id, such that the same seed gives
+// the same sample regardless of the order or sharding of the files. The samples of
+// a smaller fraction are contained in the samples of a larger fraction with the same seed.
+func sampled(hh *model.Household, fraction float64, seed int64) bool {
+	if fraction <= 0 || fraction >= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, uint64(seed))
+	binary.LittleEndian.PutUint64(b[8:], uint64(hh.ID))
+	h.Write(b)
+	return float64(h.Sum64()>>11)/(1<<53) < fraction
+}
+
+// keep reports whether a household passes the filter and the sample of the options
+func (o *SynthReadOptions) keep(hh *model.Household) bool {
+	return o.Filter.Match(hh) && sampled(hh, o.Sample, o.SampleSeed)
+}
+
+// FilterHouseholds returns the households that pass the Filter and Sample of the
+// options, for households that are not read by ReadSynthFileWithOptions, such as
+// those of ReadSynthBinaryFile. The other options are not used.
+func FilterHouseholds(hhs <-chan *model.Household, options SynthReadOptions) <-chan *model.Household {
+	c := make(chan *model.Household)
+
+	go func() {
+		defer close(c)
+		for hh := range hhs {
+			if options.keep(hh) {
+				c <- hh
+			}
+		}
+	}()
+
+	return c
+}
@@ -0,0 +1,204 @@
+package synth
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"bitbucket.org/SeheonKim/albatros4/mat"
+	"bitbucket.org/SeheonKim/albatros4/tools"
+)
+
+// maxHierarchicalIterations is the maximum number of iterations of the hierarchical fit
+const maxHierarchicalIterations = 100
+
+// PersonControls defines the person level control totals for a subzone.
+// A negative total means there is no control for that attribute.
+//
+// Workers counts the working heads of the households. Their number follows from the
+// work household dimension, whose totals are also fitted to the household marginals,
+// so a workers total that contradicts those marginals cannot be met together with
+// them; the fit then stops at the closest compromise and reports the difference.
+// Drivers counts the drivers among all members and needs Drivers as one of the
+// independent vars.
+type PersonControls struct {
+	Id      int `csv:"subzone"`
+	Workers float64
+	Drivers float64
+}
+
+// ReadPersonControls loads a file with person level control totals per subzone.
+func ReadPersonControls(filename string) map[int]*PersonControls {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer f.Close()
+
+	m := make(map[int]*PersonControls)
+	csv := tools.NewCsvReader(f, '\t')
+	for {
+		r := new(PersonControls)
+		err := csv.Read(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panicln(err)
+		}
+		if _, exists := m[r.Id]; exists {
+			log.Panicln("Double subzone", r.Id, "found in person controls file")
+		}
+		m[r.Id] = r
+	}
+	return m
+}
+
+// fitCell is a non empty cell of a multiway table during the hierarchical fit
+type fitCell struct {
+	index   mat.Index
+	weight  float64
+	persons []float64 // number of persons counted by each person constraint
+}
+
+// personConstraint is a person level control total and a function that counts
+// the persons in a household of a multiway table cell for it.
+type personConstraint struct {
+	name  string
+	total float64
+	count func(index mat.Index) float64
+}
+
+// personConstraints returns the person constraints that apply for the given controls.
+func personConstraints(controls *PersonControls, indepVars []string) (r []personConstraint) {
+	if controls == nil {
+		return
+	}
+
+	if controls.Workers >= 0 {
+		r = append(r, personConstraint{"workers", controls.Workers, func(index mat.Index) float64 {
+			household, work1, _, work2, _ := RWorkHousehold(index[0])
+			workers := 0.0
+			if work1 != 0 {
+				workers++
+			}
+			if household > 0 && work2 != 0 {
+				workers++
+			}
+			return workers
+		}})
+	}
+
+	for i, name := range indepVars {
+		if name != "Drivers" || controls.Drivers < 0 {
+			continue
+		}
+		dim := i + 2
+		r = append(r, personConstraint{"drivers", controls.Drivers, func(index mat.Index) float64 {
+			return float64(index[dim])
+		}})
+	}
+	return
+}
+
+// fitHierarchical fits the fitted multiway table jointly to the household level
+// marginals (colTotals over the work household dimension and rowTotals over the
+// age household dimension) and the person level controls of the subzone, using
+// iterative proportional updating. Empty cells stay empty. It returns the
+// largest relative difference to any of the constraints.
+func fitHierarchical(fmwt *mat.Mat, colTotals, rowTotals []float64, constraints []personConstraint, convLevel float64) (convergence float64) {
+	var cells []*fitCell
+	index := make(mat.Index, len(fmwt.Dims))
+	for {
+		if w := fmwt.At(index...); w > 0 {
+			c := &fitCell{index: append(mat.Index(nil), index...), weight: w}
+			for _, p := range constraints {
+				c.persons = append(c.persons, p.count(c.index))
+			}
+			cells = append(cells, c)
+		}
+		if index.Inc(fmwt.Dims) {
+			break
+		}
+	}
+
+	fitMarginal := func(dim int, totals []float64) {
+		sums := make([]float64, len(totals))
+		for _, c := range cells {
+			sums[c.index[dim]] += c.weight
+		}
+		for _, c := range cells {
+			if s := sums[c.index[dim]]; s > 0 {
+				c.weight *= totals[c.index[dim]] / s
+			}
+		}
+	}
+
+	fitPersons := func(k int) {
+		s := 0.0
+		for _, c := range cells {
+			s += c.weight * c.persons[k]
+		}
+		if s == 0 {
+			return
+		}
+		f := constraints[k].total / s
+		for _, c := range cells {
+			if c.persons[k] > 0 {
+				c.weight *= f
+			}
+		}
+	}
+
+	for iteration := 0; iteration < maxHierarchicalIterations; iteration++ {
+		fitMarginal(0, colTotals)
+		fitMarginal(1, rowTotals)
+		for k := range constraints {
+			fitPersons(k)
+		}
+
+		convergence = hierarchicalConvergence(cells, colTotals, rowTotals, constraints)
+		if convergence <= convLevel {
+			break
+		}
+	}
+
+	for _, c := range cells {
+		fmwt.Mul(c.weight/fmwt.At(c.index...), c.index...)
+	}
+	return
+}
+
+// hierarchicalConvergence returns the largest relative difference between the
+// weighted cells and any of the household and person constraints.
+func hierarchicalConvergence(cells []*fitCell, colTotals, rowTotals []float64, constraints []personConstraint) (r float64) {
+	relative := func(value, total float64) float64 {
+		if total == 0 {
+			return math.Abs(value)
+		}
+		return math.Abs(value-total) / total
+	}
+
+	cols := make([]float64, len(colTotals))
+	rows := make([]float64, len(rowTotals))
+	persons := make([]float64, len(constraints))
+	for _, c := range cells {
+		cols[c.index[0]] += c.weight
+		rows[c.index[1]] += c.weight
+		for k := range constraints {
+			persons[k] += c.weight * c.persons[k]
+		}
+	}
+
+	for i := range cols {
+		r = max(r, relative(cols[i], colTotals[i]))
+	}
+	for i := range rows {
+		r = max(r, relative(rows[i], rowTotals[i]))
+	}
+	for k, p := range constraints {
+		r = max(r, relative(persons[k], p.total))
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"bitbucket.org/SeheonKim/albatros4/mat"
+)
+
+func TestPersonConstraints(t *testing.T) {
+	constraints := personConstraints(&PersonControls{Workers: 10, Drivers: 20}, []string{"Child", "Drivers"})
+	if len(constraints) != 2 || constraints[0].name != "workers" || constraints[1].name != "drivers" {
+		t.Fatalf("constraints are %v, want workers and drivers", constraints)
+	}
+
+	tests := []struct {
+		workHousehold   int
+		drivers         int
+		wantWorkers     float64
+		wantDriverCount float64
+	}{
+		{WorkHouseholdToIndex.Map(UV{0, 3}), 0, 0, 0}, // independent male without work
+		{WorkHouseholdToIndex.Map(UV{3, 1}), 1, 1, 1}, // independent working female
+		{WorkHouseholdToIndex.Map(UV{0, 0}), 2, 0, 2}, // two heads without work
+		{WorkHouseholdToIndex.Map(UV{2, 0}), 3, 1, 3}, // one working head, drivers among the other members
+		{WorkHouseholdToIndex.Map(UV{1, 2}), 3, 2, 3},
+	}
+	for _, test := range tests {
+		index := mat.Index{test.workHousehold, 0, 0, test.drivers}
+		if got := constraints[0].count(index); got != test.wantWorkers {
+			t.Errorf("workers of %v is %v, want %v", index, got, test.wantWorkers)
+		}
+		if got := constraints[1].count(index); got != test.wantDriverCount {
+			t.Errorf("drivers of %v is %v, want %v", index, got, test.wantDriverCount)
+		}
+	}
+
+	if got := personConstraints(&PersonControls{Workers: -1, Drivers: 20}, []string{"Child"}); len(got) != 0 {
+		t.Errorf("constraints without controls that apply are %v, want none", got)
+	}
+}
+
+func TestFitHierarchical(t *testing.T) {
+	indepVars := []string{"Drivers"}
+	dims := []int{len(WorkHouseholdToXY), len(AgeHouseholdToXY), IndepVarLevels["Drivers"]}
+
+	// The known population is a random table, the fit starts from a uniform seed.
+	rng := rand.New(rand.NewSource(1))
+	known := mat.Zeroes(dims...)
+	seed := mat.Zeroes(dims...)
+	index := make(mat.Index, len(dims))
+	for {
+		known.Inc(index...)
+		known.Mul(1+9*rng.Float64(), index...)
+		seed.Inc(index...)
+		if index.Inc(dims) {
+			break
+		}
+	}
+
+	colTotals := make([]float64, dims[0])
+	rowTotals := make([]float64, dims[1])
+	controls := &PersonControls{}
+	all := personConstraints(&PersonControls{}, indepVars)
+	for {
+		w := known.At(index...)
+		colTotals[index[0]] += w
+		rowTotals[index[1]] += w
+		controls.Workers += w * all[0].count(index)
+		controls.Drivers += w * all[1].count(index)
+		if index.Inc(dims) {
+			break
+		}
+	}
+
+	constraints := personConstraints(controls, indepVars)
+	if convergence := fitHierarchical(seed, colTotals, rowTotals, constraints, 1e-6); convergence > 1e-6 {
+		t.Fatalf("fit didn't converge, there is a %g difference", convergence)
+	}
+
+	cols := make([]float64, dims[0])
+	rows := make([]float64, dims[1])
+	workers, drivers := 0.0, 0.0
+	for {
+		w := seed.At(index...)
+		cols[index[0]] += w
+		rows[index[1]] += w
+		workers += w * constraints[0].count(index)
+		drivers += w * constraints[1].count(index)
+		if index.Inc(dims) {
+			break
+		}
+	}
+
+	near := func(got, want float64) bool { return math.Abs(got-want) <= 1e-4*want }
+	for i := range cols {
+		if !near(cols[i], colTotals[i]) {
+			t.Errorf("work household total %d is %v, want %v", i, cols[i], colTotals[i])
+		}
+	}
+	for i := range rows {
+		if !near(rows[i], rowTotals[i]) {
+			t.Errorf("age household total %d is %v, want %v", i, rows[i], rowTotals[i])
+		}
+	}
+	if !near(workers, controls.Workers) {
+		t.Errorf("workers are %v, want %v", workers, controls.Workers)
+	}
+	if !near(drivers, controls.Drivers) {
+		t.Errorf("drivers are %v, want %v", drivers, controls.Drivers)
+	}
+}
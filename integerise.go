@@ -0,0 +1,312 @@
+package synth
+
+import (
+	"encoding/csv"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+
+	"bitbucket.org/SeheonKim/albatros4/mat"
+)
+
+// IntegerisationMethod defines how a fitted multiway table is rounded to whole households
+type IntegerisationMethod string
+
+const (
+	// IntegeriseInvariant rounds with mat.Mat.InvariantRound
+	IntegeriseInvariant IntegerisationMethod = "invariant"
+	// IntegeriseTRS truncates every cell, replicates the integer parts and samples the
+	// remaining households over the cells with a probability proportional to their fractions.
+	IntegeriseTRS IntegerisationMethod = "trs"
+	// IntegeriseControlled rounds such that the number of households of every age work
+	// household combination and the total number of households stay as close as possible
+	// to the fitted values, using largest remainders.
+	IntegeriseControlled IntegerisationMethod = "controlled"
+	// IntegeriseStochastic rounds every cell up with a probability equal to its fraction
+	IntegeriseStochastic IntegerisationMethod = "stochastic"
+)
+
+// IntegerisationError is the marginal error integerisation introduced in a subzone.
+// The errors are the total absolute differences between the rounded and the fitted table.
+type IntegerisationError struct {
+	Subzone       int
+	Total         float64
+	WorkHousehold float64
+	AgeHousehold  float64
+}
+
+// matStrides returns for each dimension the distance in Vals between two neighbouring
+// cells in that dimension. It is found by probing, because mat does not expose its layout.
+func matStrides(dims []int) []int {
+	strides := make([]int, len(dims))
+	probe := mat.Zeroes(dims...)
+	index := make([]int, len(dims))
+	for d := range dims {
+		if dims[d] < 2 {
+			continue
+		}
+		index[d] = 1
+		probe.Inc(index...)
+		for i, v := range probe.Vals {
+			if v != 0 {
+				strides[d] = i
+				probe.Vals[i] = 0
+				break
+			}
+		}
+		index[d] = 0
+	}
+	return strides
+}
+
+// offset returns the position in Vals for an index given the strides of a table
+func offset(index []int, strides []int) (o int) {
+	for i := range index {
+		o += index[i] * strides[i]
+	}
+	return
+}
+
+// householdMarginals returns the sums of the table over the work household (first)
+// and the age household (second) dimension.
+func householdMarginals(m *mat.Mat) (work, age []float64) {
+	work = make([]float64, m.Dims[0])
+	age = make([]float64, m.Dims[1])
+	index := make(mat.Index, len(m.Dims))
+	for {
+		v := m.At(index...)
+		work[index[0]] += v
+		age[index[1]] += v
+		if index.Inc(m.Dims) {
+			break
+		}
+	}
+	return
+}
+
+// totalAbsoluteError returns the sum of the absolute differences between a and b
+func totalAbsoluteError(a, b []float64) (r float64) {
+	for i := range a {
+		r += math.Abs(a[i] - b[i])
+	}
+	return
+}
+
+// integerise rounds the fitted multiway table of a subzone with the given method
+// and returns the marginal error this introduced.
+func integerise(subzone int, fmwt *mat.Mat, method IntegerisationMethod, rng *rand.Rand) IntegerisationError {
+	work, age := householdMarginals(fmwt)
+	total := sum(fmwt.Vals)
+
+	switch method {
+	case "", IntegeriseInvariant:
+		fmwt.InvariantRound()
+	case IntegeriseTRS:
+		roundTRS(fmwt.Vals, rng)
+	case IntegeriseControlled:
+		roundControlled(fmwt)
+	case IntegeriseStochastic:
+		for i, v := range fmwt.Vals {
+			fmwt.Vals[i] = math.Floor(v)
+			if rng.Float64() < v-fmwt.Vals[i] {
+				fmwt.Vals[i]++
+			}
+		}
+	default:
+		log.Panicln("Unknown integerisation method", method)
+	}
+
+	rwork, rage := householdMarginals(fmwt)
+	return IntegerisationError{
+		Subzone:       subzone,
+		Total:         math.Abs(sum(fmwt.Vals) - total),
+		WorkHousehold: totalAbsoluteError(rwork, work),
+		AgeHousehold:  totalAbsoluteError(rage, age),
+	}
+}
+
+// roundTRS rounds vals by truncation, replication and sampling. The total is
+// rounded to the nearest integer. The cells that are rounded up are drawn without
+// replacement with a probability proportional to their fractions.
+func roundTRS(vals []float64, rng *rand.Rand) {
+	fractions := make([]float64, len(vals))
+	remaining := math.Round(sum(vals))
+	for i, v := range vals {
+		vals[i] = math.Floor(v)
+		fractions[i] = v - vals[i]
+		remaining -= vals[i]
+	}
+
+	tree := newFenwickTree(fractions)
+	total := sum(fractions)
+	for ; remaining > 0 && total > 0; remaining-- {
+		pick := tree.search(rng.Float64() * total)
+		if fractions[pick] <= 0 {
+			// Rounding errors in the tree can hit an empty cell, take the nearest one that is not
+			pick = nearestPositive(fractions, pick)
+			if pick == -1 {
+				break
+			}
+		}
+		vals[pick]++
+		tree.add(pick, -fractions[pick])
+		total -= fractions[pick]
+		fractions[pick] = 0
+	}
+}
+
+// nearestPositive returns the index of the positive value nearest to i, or -1 if there is none
+func nearestPositive(vals []float64, i int) int {
+	for d := 1; d < len(vals); d++ {
+		if i-d >= 0 && vals[i-d] > 0 {
+			return i - d
+		}
+		if i+d < len(vals) && vals[i+d] > 0 {
+			return i + d
+		}
+	}
+	return -1
+}
+
+// fenwickTree holds the cumulative sums of values, such that a value can be changed and
+// the value at a cumulative sum can be found in logarithmic time.
+type fenwickTree []float64
+
+// newFenwickTree creates the tree of vals
+func newFenwickTree(vals []float64) fenwickTree {
+	t := make(fenwickTree, len(vals)+1)
+	copy(t[1:], vals)
+	for i := 1; i < len(t); i++ {
+		if j := i + i&-i; j < len(t) {
+			t[j] += t[i]
+		}
+	}
+	return t
+}
+
+// add adds v to value i
+func (t fenwickTree) add(i int, v float64) {
+	for i++; i < len(t); i += i & -i {
+		t[i] += v
+	}
+}
+
+// search returns the first value at which the cumulative sum exceeds r
+func (t fenwickTree) search(r float64) int {
+	step := 1
+	for step*2 < len(t) {
+		step *= 2
+	}
+
+	i := 0
+	for ; step > 0; step /= 2 {
+		if i+step < len(t) && t[i+step] <= r {
+			i += step
+			r -= t[i]
+		}
+	}
+	return min(i, len(t)-2)
+}
+
+// largestRemainder rounds vals down and then up for the cells with the largest
+// fractions until the given total is reached.
+func largestRemainder(vals []float64, total float64) []float64 {
+	r := make([]float64, len(vals))
+	order := make([]int, len(vals))
+	for i, v := range vals {
+		r[i] = math.Floor(v)
+		total -= r[i]
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return vals[order[a]]-r[order[a]] > vals[order[b]]-r[order[b]]
+	})
+	for _, i := range order {
+		if total < 1 {
+			break
+		}
+		if vals[i] > 0 {
+			r[i]++
+			total--
+		}
+	}
+	return r
+}
+
+// roundControlled first rounds the number of households per age work household
+// combination with largest remainders such that the total is kept and then rounds
+// the cells within each combination to that number.
+func roundControlled(fmwt *mat.Mat) {
+	strides := matStrides(fmwt.Dims)
+
+	groups := make(map[[2]int][]int)
+	var keys [][2]int
+	index := make(mat.Index, len(fmwt.Dims))
+	for {
+		key := [2]int{index[0], index[1]}
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], offset(index, strides))
+		if index.Inc(fmwt.Dims) {
+			break
+		}
+	}
+
+	totals := make([]float64, len(keys))
+	for i, key := range keys {
+		for _, o := range groups[key] {
+			totals[i] += fmwt.Vals[o]
+		}
+	}
+	totals = largestRemainder(totals, math.Round(sum(totals)))
+
+	for i, key := range keys {
+		offsets := groups[key]
+		vals := make([]float64, len(offsets))
+		for j, o := range offsets {
+			vals[j] = fmwt.Vals[o]
+		}
+		for j, v := range largestRemainder(vals, totals[i]) {
+			fmwt.Vals[offsets[j]] = v
+		}
+	}
+}
+
+// WriteIntegerisationReport writes the integerisation errors per subzone to a csv file
+// and logs the totals over all subzones.
+func WriteIntegerisationReport(filename string, errs []IntegerisationError) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"Subzone", "Total", "WorkHousehold", "AgeHousehold"})
+	var total IntegerisationError
+	for _, e := range errs {
+		w.Write([]string{d(e.Subzone), s(e.Total), s(e.WorkHousehold), s(e.AgeHousehold)})
+		total.Total += e.Total
+		total.WorkHousehold += e.WorkHousehold
+		total.AgeHousehold += e.AgeHousehold
+	}
+
+	log.Printf("Integerisation error over %d subzones: total %.0f, work household %.0f, age household %.0f",
+		len(errs), total.Total, total.WorkHousehold, total.AgeHousehold)
+	return flushCsv(w, f)
+}
+
+// flushCsv flushes w and closes the file it writes to. It returns the first error of
+// any write, the flush or the close.
+func flushCsv(w *csv.Writer, f *os.File) error {
+	w.Flush()
+	err := w.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
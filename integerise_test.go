@@ -0,0 +1,61 @@
+package synth
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFenwickTreeSearch(t *testing.T) {
+	vals := []float64{0.5, 0, 0.25, 1, 0, 0.75, 0.5}
+	tree := newFenwickTree(vals)
+	tests := []struct {
+		r    float64
+		want int
+	}{
+		{0, 0}, {0.49, 0}, {0.5, 2}, {0.74, 2}, {0.75, 3}, {1.74, 3}, {1.75, 5}, {2.49, 5}, {2.5, 6}, {2.99, 6}, {5, 6},
+	}
+	for _, test := range tests {
+		if got := tree.search(test.r); got != test.want {
+			t.Errorf("search(%v) is %d, want %d", test.r, got, test.want)
+		}
+	}
+
+	tree.add(3, -1)
+	if got := tree.search(0.75); got != 5 {
+		t.Errorf("search(0.75) after removing cell 3 is %d, want 5", got)
+	}
+}
+
+func TestRoundTRS(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vals := []float64{0.9, 2.1, 0, 3.5, 0.5, 1.0}
+	ups := make([]int, len(vals))
+	const runs = 10000
+	for run := 0; run < runs; run++ {
+		r := append([]float64{}, vals...)
+		roundTRS(r, rng)
+		if sum(r) != math.Round(sum(vals)) {
+			t.Fatalf("rounded total is %v, want %v", sum(r), math.Round(sum(vals)))
+		}
+		for i, v := range r {
+			if v != math.Floor(vals[i]) && v != math.Floor(vals[i])+1 {
+				t.Fatalf("cell %d of %v is rounded to %v", i, vals[i], v)
+			}
+			if v > math.Floor(vals[i]) {
+				ups[i]++
+			}
+		}
+	}
+
+	// Two of the fractions 0.9, 0.1, 0.5 and 0.5 are rounded up in every run
+	if ups[2] != 0 || ups[5] != 0 {
+		t.Errorf("cells without a fraction are rounded up %d and %d times", ups[2], ups[5])
+	}
+	if ups[0] < 5*ups[1] {
+		t.Errorf("fraction 0.9 is rounded up %d times and 0.1 %d times", ups[0], ups[1])
+	}
+	if n := ups[0] + ups[1] + ups[3] + ups[4]; n != 2*runs {
+		t.Errorf("%d cells are rounded up, want %d", n, 2*runs)
+	}
+}
@@ -0,0 +1,86 @@
+package synth
+
+import (
+	"log"
+	"time"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// memberKey is the condition under which the other members of a household are drawn
+type memberKey struct {
+	child  model.Child
+	maxAge model.Age
+}
+
+// seedMembers are the members other than the heads of a seed household
+type seedMembers struct {
+	members []model.Person
+	weight  float64
+}
+
+// memberModel draws the members other than the heads of a synthesized household
+// from the seed households with the same child category and maximum age of the heads.
+type memberModel struct {
+	byKey   map[memberKey][]seedMembers
+	byChild map[model.Child][]seedMembers
+}
+
+// readMemberModel reads the members other than the heads of all seed households
+func readMemberModel(source SeedSource) *memberModel {
+	log.Println("Reading seed household members")
+	start := time.Now()
+
+	m := &memberModel{
+		byKey:   make(map[memberKey][]seedMembers),
+		byChild: make(map[model.Child][]seedMembers),
+	}
+	for hh := range source.Households() {
+		s := seedMembers{weight: hh.Weight}
+		for _, mem := range hh.Member {
+			if !mem.Head {
+				s.members = append(s.members, *mem)
+			}
+		}
+
+		key := memberKey{hh.Child, hh.MaxAge}
+		m.byKey[key] = append(m.byKey[key], s)
+		m.byChild[hh.Child] = append(m.byChild[hh.Child], s)
+	}
+
+	log.Println("Done reading seed household members in", time.Since(start))
+	return m
+}
+
+// draw returns new members for a household with the given child category and maximum
+// age of the heads, copied from a seed household drawn with a probability proportional
+// to its weight. When no seed household has the same maximum age only the child
+// category is used. The ids of the members start at firstID. The drawn members are no
+// drivers yet, the synthesis assigns the drivers of the household afterwards.
+func (m *memberModel) draw(child model.Child, maxAge model.Age, firstID int) (members []*model.Person) {
+	candidates := m.byKey[memberKey{child, maxAge}]
+	if len(candidates) == 0 {
+		candidates = m.byChild[child]
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	weights := make([]float64, len(candidates))
+	for i, c := range candidates {
+		weights[i] = c.weight
+	}
+	if sum(weights) == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	for i, mem := range candidates[drawCategory(weights)].members {
+		p := mem
+		p.ID = firstID + i
+		p.IsDriver = false
+		members = append(members, &p)
+	}
+	return
+}
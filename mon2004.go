@@ -29,6 +29,9 @@ type MonMember struct {
 	Drivers          int
 	Sec              int
 	Day              int
+	Weight           float64 // Person expansion weight
+	HouseholdWeight  float64 // Household expansion weight
+	FirstHead        bool    // Set for the first head of the household only
 	AgeHousehold     UV
 	WorkHousehold    UV
 	AgeWorkHousehold UV
@@ -211,105 +214,135 @@ func min(a, b int) int {
 	return b
 }
 
-func parseHouseholds(in <-chan *model.Household, out chan *MonMember) {
+// parseHouseholds classifies all members of the households from in into the
+// spatial segments of the given segmentation and sends them on out.
+func parseHouseholds(in <-chan *SeedHousehold, out chan *MonMember, segmentation *SpatialSegmentation) {
 	for hh := range in {
 		// if err := model.CleanData(hh); err != nil { // Only drop household does not meet 7 cleaning criteria // see CleanData()
 		// 	log.Printf("Dropping houshold %d: %s\n", hh.ID, err)
 		// 	continue
 		// }
 
-		numDrivers := 0
-		numHeads := 0
-		for _, mem := range hh.Member {
-			if mem.IsDriver {
-				numDrivers++
-			}
-			if mem.Head {
-				numHeads++
+		segment := segmentation.Segment(int(hh.Urb), hh.Prov)
+		firstHead := true
+		for i, m := range classifyHousehold(hh.Household) {
+			m.SpatialSegment = segment
+			m.Weight = hh.PersonWeight(i)
+			m.HouseholdWeight = hh.Weight
+			if hh.Member[i].Head {
+				m.FirstHead = firstHead
+				firstHead = false
 			}
+			out <- m
 		}
+	}
+}
 
-		for _, mem := range hh.Member {
-			var m MonMember
+// classifyHousehold returns a classified MonMember for every member of the household.
+// The spatial segment is not set.
+func classifyHousehold(hh *model.Household) (members []*MonMember) {
+	numDrivers := 0
+	numHeads := 0
+	for _, mem := range hh.Member {
+		if mem.IsDriver {
+			numDrivers++
+		}
+		if mem.Head {
+			numHeads++
+		}
+	}
+
+	for _, mem := range hh.Member {
+		var m MonMember
+
+		m.Hhid = hh.ID
+		m.Work = int(mem.Work)
+		m.NumCars = min(int(hh.NumCars), 2)
+		m.Age = int(hh.MaxAge)
+		if mem.Head {
+			m.Household = numHeads - 1
+		} else {
+			m.Household = 2
+		}
+		m.Child = int(hh.Child)
+		m.Drivers = min(numDrivers, 3)
+		m.Sec = int(hh.Sec)
 
-			m.Hhid = hh.ID
-			m.SpatialSegment = C_spatial_segment(int(hh.Urb), hh.Prov)
-			m.Work = int(mem.Work)
-			m.NumCars = min(int(hh.NumCars), 2)
-			m.Age = int(hh.MaxAge)
-			if mem.Head {
-				m.Household = numHeads - 1
+		m.Day = int(hh.Day)
+
+		// AgeHousehold
+		if m.Household == 0 { // Independent
+			if mem.Gender == model.Male {
+				m.AgeHousehold.U, m.AgeHousehold.V = m.Age, 5
 			} else {
-				m.Household = 2
+				m.AgeHousehold.U, m.AgeHousehold.V = 5, m.Age
+			}
+		} else if m.Household == 1 { // two adult household
+			male, female := partners(hh)
+			m.AgeHousehold.U, m.AgeHousehold.V = int(male.Age), int(female.Age)
+			if p, exists := AgeHouseholdRemap[m.AgeHousehold]; exists {
+				m.AgeHousehold = p
 			}
-			m.Child = int(hh.Child)
-			m.Drivers = min(numDrivers, 3)
-			m.Sec = int(hh.Sec)
-
-			m.Day = int(hh.Day)
-
-			// AgeHousehold
-			if m.Household == 0 { // Independent
-				if mem.Gender == model.Male {
-					m.AgeHousehold.U, m.AgeHousehold.V = m.Age, 5
-				} else {
-					m.AgeHousehold.U, m.AgeHousehold.V = 5, m.Age
-				}
-			} else if m.Household == 1 { // two adult household
-				male, female := partners(hh)
-				m.AgeHousehold.U, m.AgeHousehold.V = int(male.Age), int(female.Age)
-				if p, exists := AgeHouseholdRemap[m.AgeHousehold]; exists {
-					m.AgeHousehold = p
-				}
+		} else {
+			if mem.Gender == model.Male { // living in
+				m.AgeHousehold.U, m.AgeHousehold.V = m.Age, 6
 			} else {
-				if mem.Gender == model.Male { // living in
-					m.AgeHousehold.U, m.AgeHousehold.V = m.Age, 6
-				} else {
-					m.AgeHousehold.U, m.AgeHousehold.V = 6, m.Age
-				}
+				m.AgeHousehold.U, m.AgeHousehold.V = 6, m.Age
 			}
+		}
 
-			// WorkHousehold
-			if m.Household == 0 { // Independent
-				if mem.Gender == model.Male {
-					m.WorkHousehold.U, m.WorkHousehold.V = m.Work, 3
-				} else {
-					m.WorkHousehold.U, m.WorkHousehold.V = 3, m.Work
-				}
-			} else if m.Household == 1 { // two adult household
-				male, female := partners(hh)
-				m.WorkHousehold.U, m.WorkHousehold.V = int(male.Work), int(female.Work)
-			} else { // living in
-				if mem.Gender == model.Male {
-					m.WorkHousehold.U, m.WorkHousehold.V = m.Work, 4
-				} else {
-					m.WorkHousehold.U, m.WorkHousehold.V = 4, m.Work
-				}
+		// WorkHousehold
+		if m.Household == 0 { // Independent
+			if mem.Gender == model.Male {
+				m.WorkHousehold.U, m.WorkHousehold.V = m.Work, 3
+			} else {
+				m.WorkHousehold.U, m.WorkHousehold.V = 3, m.Work
 			}
-
-			// AgeWorkHouseold
-			m.AgeWorkHousehold.U = WorkHouseholdToIndex.Map(m.WorkHousehold)
-			m.AgeWorkHousehold.V = AgeHouseholdToIndex.Map(m.AgeHousehold)
-			if m.AgeWorkHousehold.U == -1 || m.AgeWorkHousehold.V == -1 {
-				m.AgeWorkHousehold = UV{-1, -1}
+		} else if m.Household == 1 { // two adult household
+			male, female := partners(hh)
+			m.WorkHousehold.U, m.WorkHousehold.V = int(male.Work), int(female.Work)
+		} else { // living in
+			if mem.Gender == model.Male {
+				m.WorkHousehold.U, m.WorkHousehold.V = m.Work, 4
+			} else {
+				m.WorkHousehold.U, m.WorkHousehold.V = 4, m.Work
 			}
+		}
 
-			out <- &m
-
+		// AgeWorkHouseold
+		m.AgeWorkHousehold.U = WorkHouseholdToIndex.Map(m.WorkHousehold)
+		m.AgeWorkHousehold.V = AgeHouseholdToIndex.Map(m.AgeHousehold)
+		if m.AgeWorkHousehold.U == -1 || m.AgeWorkHousehold.V == -1 {
+			m.AgeWorkHousehold = UV{-1, -1}
 		}
+
+		members = append(members, &m)
 	}
+	return
 }
 
 // ReadMonData returns a channel on with MonMembers will be returned.
 // You must read all members until the channel is closed.
 func ReadMonData(filename string) <-chan *MonMember {
+	return ReadMonDataSegmented(filename, DefaultSpatialSegmentation)
+}
+
+// ReadMonDataSegmented is like ReadMonData but classifies the members into the
+// spatial segments of the given segmentation.
+func ReadMonDataSegmented(filename string, segmentation *SpatialSegmentation) <-chan *MonMember {
+	return ReadSeedData(&MonSeedSource{Filename: filename}, segmentation)
+}
+
+// ReadSeedData returns a channel on which the classified members of the households
+// of the seed source will be returned. You must read all members until the channel is closed.
+func ReadSeedData(source SeedSource, segmentation *SpatialSegmentation) <-chan *MonMember {
 	c := make(chan *MonMember, 10)
 
 	go func() {
 		defer close(c)
 
-		hh := model.ReadMonFile(filename) // read Household data from MON data
-		parseHouseholds(hh, c)            // convert Household to MonMember
+		hh := source.Households()            // read Household data from the seed source
+		parseHouseholds(hh, c, segmentation) // convert Household to MonMember
 	}()
 
 	return c
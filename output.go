@@ -1,11 +1,11 @@
 package synth
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log"
-	"os"
 
 	"bitbucket.org/SeheonKim/albatros4/model"
 )
@@ -18,92 +18,383 @@ func d(v interface{}) string {
 	return fmt.Sprintf("%d", v)
 }
 
-func WriteCsv(out io.Writer, hhs <-chan *model.Household) {
-	csv := csv.NewWriter(out)
-	defer csv.Flush()
-
-	csv.Write([]string{
-		"Hhid",
-		"Home",
-		"Gem",
-		"Urb",
-		"Comp",
-		"Child",
-		"Day",
-		"SEC",
-		"Ncar",
-		"Driver",
-		"FEV",
-		"PHEV",
-		"Age1",
-		"Gender1",
-		"Work1",
-		"Driver1",
-		"Age2",
-		"Gender2",
-		"Work2",
-		"Driver2",
+// drain reads the remaining households so the sender is not blocked after a write error
+func drain[T any](hhs <-chan T) {
+	go func() {
+		for range hhs {
+		}
+	}()
+}
+
+// writeRows writes the header and the rows created by records for every household,
+// separated by comma. It never modifies the households. It returns the number of rows
+// written, excluding the header, and the first write error. After an error the remaining
+// households are drained.
+func writeRows(out io.Writer, comma rune, header []string, hhs <-chan *model.Household, records func(*model.Household) [][]string) (int, error) {
+	rows, err := writeRecords(out, comma, header, func() ([][]string, bool) {
+		hh, ok := <-hhs
+		if !ok {
+			return nil, false
+		}
+		return records(hh), true
 	})
+	if err != nil {
+		drain(hhs)
+	}
+	return rows, err
+}
 
-	for hh := range hhs {
-		var Age1 = 999999
-		var Gender1 = 999999
-		var Work1 = 999999
-		var Driver1 = 999999
-		var Age2 = 999999
-		var Gender2 = 999999
-		var Work2 = 999999
-		var Driver2 = 999999
-
-		for i := range hh.Member {
-			if i == 0 {
-				Age1 = int(hh.Member[i].Age)
-				Gender1 = int(hh.Member[i].Gender)
-				Work1 = int(hh.Member[i].Work)
-				if hh.Member[i].IsDriver {
-					Driver1 = 1
-					hh.Driver = 1
-				}
-			} else {
-				Age2 = int(hh.Member[i].Age)
-				Gender2 = int(hh.Member[i].Gender)
-				Work2 = int(hh.Member[i].Work)
-				if hh.Member[i].IsDriver {
-					Driver2 = 1
-				}
+// writeRecords writes the header and the records returned by next until it returns false.
+// It returns the number of records written, excluding the header, and the first write error.
+func writeRecords(out io.Writer, comma rune, header []string, next func() ([][]string, bool)) (rows int, err error) {
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	if err = w.Write(header); err != nil {
+		return
+	}
+
+	for {
+		records, ok := next()
+		if !ok {
+			break
+		}
+		for _, record := range records {
+			if err = w.Write(record); err != nil {
+				return
 			}
+			rows++
+		}
+	}
+
+	w.Flush()
+	err = w.Error()
+	return
+}
+
+// writeFile creates filename with createFile and writes to it with write. The error of
+// closing the file is returned when write succeeded.
+func writeFile(filename string, hhs <-chan *model.Household, write func(io.Writer, <-chan *model.Household) (int, error)) (rows int, err error) {
+	f, err := createFile(filename)
+	if err != nil {
+		drain(hhs)
+		return 0, err
+	}
+
+	rows, err = write(f, hhs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// csvHeader is the header of the household file written by WriteCsv
+var csvHeader = func() (header []string) {
+	for _, c := range synthColumns {
+		header = append(header, c.name)
+	}
+	return
+}()
+
+// csvRecord returns the WriteCsv row of a household
+func csvRecord(hh *model.Household) [][]string {
+	return [][]string{newSynthData(hh).record()}
+}
+
+// WriteCsv writes the households with the columns of SynthData, such that ReadSynthFile
+// reads them back. At most two heads are written per household, the other members are
+// dropped; use WritePersonsCsv or BinaryFormat to keep them. It returns the number of
+// households written and the first write error.
+func WriteCsv(out io.Writer, hhs <-chan *model.Household) (int, error) {
+	return writeRows(out, ',', csvHeader, hhs, csvRecord)
+}
+
+// WriteCsvFile writes the households with WriteCsv to filename
+func WriteCsvFile(filename string, hhs <-chan *model.Household) (int, error) {
+	return writeFile(filename, hhs, WriteCsv)
+}
+
+// WriteCsvWithAttrs writes the households like WriteCsv followed by a column for each
+// of the extension attributes attrs. An attribute a household does not have is written
+// empty. It returns the number of households written and the first write error.
+func WriteCsvWithAttrs(out io.Writer, attrs []string, hhs <-chan *ExtHousehold) (int, error) {
+	rows, err := writeRecords(out, ',', append(append([]string{}, csvHeader...), attrs...), func() ([][]string, bool) {
+		hh, ok := <-hhs
+		if !ok {
+			return nil, false
 		}
-		csv.Write([]string{
+		record := newSynthData(hh.Household).record()
+		for _, attr := range attrs {
+			record = append(record, hh.Attrs[attr])
+		}
+		return [][]string{record}, true
+	})
+	if err != nil {
+		drain(hhs)
+	}
+	return rows, err
+}
+
+// personsHeader is the header of a persons file
+var personsHeader = []string{
+	"Hhid",
+	"ID",
+	"Head",
+	"Age",
+	"Gender",
+	"Work",
+	"IsDriver",
+}
+
+// personRecords returns a row for every member of the household
+func personRecords(hh *model.Household) (records [][]string) {
+	for _, mem := range hh.Member {
+		records = append(records, []string{
 			d(hh.ID),
-			d(hh.Home),
-			d(hh.WoGem),
-			d(hh.Urb),
-			d(hh.Comp),
-			d(hh.Child),
-			d(hh.Day),
-			d(hh.Sec),
-			d(hh.NumCars),
-			d(hh.Driver),
-			d(hh.FEV),
-			d(hh.PHEV),
-			d(Age1),
-			d(Gender1),
-			d(Work1),
-			d(Driver1),
-			d(Age2),
-			d(Gender2),
-			d(Work2),
-			d(Driver2),
+			d(mem.ID),
+			b(mem.Head),
+			d(mem.Age),
+			d(mem.Gender),
+			d(mem.Work),
+			b(mem.IsDriver),
 		})
+	}
+	return
+}
 
+// b formats a bool as 1 or 0
+func b(v bool) string {
+	if v {
+		return "1"
 	}
+	return "0"
 }
 
-func WriteCsvFile(filename string, hhs <-chan *model.Household) {
-	f, err := os.Create(filename)
-	if err != nil {
-		log.Panic(err)
+// WritePersonsCsv writes every member of the households as a row keyed by the household id.
+// It returns the number of persons written and the first write error.
+func WritePersonsCsv(out io.Writer, hhs <-chan *model.Household) (int, error) {
+	return writeRows(out, ',', personsHeader, hhs, personRecords)
+}
+
+// WriteCsvFiles writes the households with WriteCsv to hhFilename and all their
+// members with WritePersonsCsv to personsFilename. It returns the number of households written.
+func WriteCsvFiles(hhFilename, personsFilename string, hhs <-chan *model.Household) (int, error) {
+	return writeWithPersons(personsFilename, hhs, func(hhs <-chan *model.Household) (int, error) {
+		return WriteCsvFile(hhFilename, hhs)
+	})
+}
+
+// WriteNormalisedCsvFiles writes a household table with WriteHouseholdsCsv to hhFilename
+// and a person table with WritePersonsCsv to personsFilename. It returns the number of
+// households written.
+func WriteNormalisedCsvFiles(hhFilename, personsFilename string, hhs <-chan *model.Household) (int, error) {
+	return writeWithPersons(personsFilename, hhs, func(hhs <-chan *model.Household) (int, error) {
+		return writeFile(hhFilename, hhs, WriteHouseholdsCsv)
+	})
+}
+
+// writeWithPersons writes the members of the households to personsFilename while
+// passing the households on to write. The first error of either file is returned.
+func writeWithPersons(personsFilename string, hhs <-chan *model.Household, write func(<-chan *model.Household) (int, error)) (int, error) {
+	tee := make(chan *model.Household)
+	persons := make(chan *model.Household)
+	personsDone := make(chan error)
+	go func() {
+		_, err := writeFile(personsFilename, persons, WritePersonsCsv)
+		personsDone <- err
+	}()
+	go func() {
+		defer close(tee)
+		defer close(persons)
+		for hh := range hhs {
+			persons <- hh
+			tee <- hh
+		}
+	}()
+
+	rows, err := write(tee)
+	if perr := <-personsDone; err == nil {
+		err = perr
+	}
+	return rows, err
+}
+
+// householdsHeader is the header of a normalised household table
+var householdsHeader = []string{
+	"Hhid",
+	"Home",
+	"Gem",
+	"Prov",
+	"Urb",
+	"Comp",
+	"Child",
+	"Day",
+	"SEC",
+	"Ncar",
+	"Driver",
+	"EV",
+	"FEV",
+	"PHEV",
+	"Members",
+}
+
+// householdRecord returns the normalised row of a household
+func householdRecord(hh *model.Household) [][]string {
+	return [][]string{{
+		d(hh.ID),
+		d(hh.Home),
+		d(hh.WoGem),
+		d(hh.Prov),
+		d(hh.Urb),
+		d(hh.Comp),
+		d(hh.Child),
+		d(hh.Day),
+		d(hh.Sec),
+		d(hh.NumCars),
+		d(hh.Driver),
+		b(hh.EV),
+		b(hh.FEV),
+		b(hh.PHEV),
+		d(len(hh.Member)),
+	}}
+}
+
+// WriteHouseholdsCsv writes the households without their members, which are written
+// by WritePersonsCsv. Unlike WriteCsv there is no limit on the number of members.
+// It returns the number of households written and the first write error.
+func WriteHouseholdsCsv(out io.Writer, hhs <-chan *model.Household) (int, error) {
+	return writeRows(out, ',', householdsHeader, hhs, householdRecord)
+}
+
+// binaryMagic starts a binary population file, followed by the version of the format
+const binaryMagic = "SYNB\x01"
+
+// binaryChunkSize is the number of households per chunk of a binary population file
+const binaryChunkSize = 4096
+
+// binaryHouseholdColumn is a household column of a binary population file
+type binaryHouseholdColumn struct {
+	get func(*model.Household) int64
+	set func(*model.Household, int64)
+}
+
+// binaryPersonColumn is a person column of a binary population file
+type binaryPersonColumn struct {
+	get func(*model.Person) int64
+	set func(*model.Person, int64)
+}
+
+// flag returns 1 if v is true and 0 otherwise
+func flag(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// binaryHouseholdColumns are the household columns of a binary population file in file order
+var binaryHouseholdColumns = []binaryHouseholdColumn{
+	{func(hh *model.Household) int64 { return int64(hh.ID) }, func(hh *model.Household, v int64) { hh.ID = int(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Home) }, func(hh *model.Household, v int64) { hh.Home = model.Location(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.WoGem) }, func(hh *model.Household, v int64) { hh.WoGem = int(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Prov) }, func(hh *model.Household, v int64) { hh.Prov = int(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Urb) }, func(hh *model.Household, v int64) { hh.Urb = model.Urb(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Comp) }, func(hh *model.Household, v int64) { hh.Comp = model.Comp(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Child) }, func(hh *model.Household, v int64) { hh.Child = model.Child(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Day) }, func(hh *model.Household, v int64) { hh.Day = model.Day(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Sec) }, func(hh *model.Household, v int64) { hh.Sec = model.Sec(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.NumCars) }, func(hh *model.Household, v int64) { hh.NumCars = int8(v) }},
+	{func(hh *model.Household) int64 { return int64(hh.Driver) }, func(hh *model.Household, v int64) { hh.Driver = int(v) }},
+	{
+		func(hh *model.Household) int64 { return flag(hh.EV) | flag(hh.FEV)<<1 | flag(hh.PHEV)<<2 },
+		func(hh *model.Household, v int64) { hh.EV, hh.FEV, hh.PHEV = v&1 != 0, v&2 != 0, v&4 != 0 },
+	},
+}
+
+// binaryPersonColumns are the person columns of a binary population file in file order
+var binaryPersonColumns = []binaryPersonColumn{
+	{func(p *model.Person) int64 { return int64(p.ID) }, func(p *model.Person, v int64) { p.ID = int(v) }},
+	{
+		func(p *model.Person) int64 { return flag(p.Head) | flag(p.IsDriver)<<1 },
+		func(p *model.Person, v int64) { p.Head, p.IsDriver = v&1 != 0, v&2 != 0 },
+	},
+	{func(p *model.Person) int64 { return int64(p.Age) }, func(p *model.Person, v int64) { p.Age = model.Age(v) }},
+	{func(p *model.Person) int64 { return int64(p.Gender) }, func(p *model.Person, v int64) { p.Gender = model.Gender(v) }},
+	{func(p *model.Person) int64 { return int64(p.Work) }, func(p *model.Person, v int64) { p.Work = model.Work(v) }},
+}
+
+// BinaryWriter writes households in a compact columnar format that is read by
+// ReadSynthBinaryFile. The households are written in chunks. A chunk starts with the
+// number of households, followed by every household column, the number of members
+// per household and every person column. Each column holds a varint per household
+// or member. A chunk of zero households ends the file.
+type BinaryWriter struct{}
+
+// Write implements Writer
+func (w *BinaryWriter) Write(out io.Writer, hhs <-chan *model.Household) (rows int, err error) {
+	buf := bufio.NewWriter(out)
+	if _, err = buf.WriteString(binaryMagic); err != nil {
+		drain(hhs)
+		return
+	}
+
+	chunk := make([]*model.Household, 0, binaryChunkSize)
+	for hh := range hhs {
+		chunk = append(chunk, hh)
+		if len(chunk) < binaryChunkSize {
+			continue
+		}
+		if err = writeBinaryChunk(buf, chunk); err != nil {
+			drain(hhs)
+			return
+		}
+		rows += len(chunk)
+		chunk = chunk[:0]
+	}
+	if len(chunk) > 0 {
+		if err = writeBinaryChunk(buf, chunk); err != nil {
+			return
+		}
+		rows += len(chunk)
+	}
+	if err = writeBinaryChunk(buf, nil); err != nil {
+		return
+	}
+
+	err = buf.Flush()
+	return
+}
+
+// writeBinaryChunk writes a chunk of households column by column
+func writeBinaryChunk(w *bufio.Writer, hhs []*model.Household) error {
+	b := make([]byte, binary.MaxVarintLen64)
+	put := func(v int64) error {
+		_, err := w.Write(b[:binary.PutVarint(b, v)])
+		return err
+	}
+
+	if err := put(int64(len(hhs))); err != nil {
+		return err
+	}
+	for _, c := range binaryHouseholdColumns {
+		for _, hh := range hhs {
+			if err := put(c.get(hh)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, hh := range hhs {
+		if err := put(int64(len(hh.Member))); err != nil {
+			return err
+		}
+	}
+	for _, c := range binaryPersonColumns {
+		for _, hh := range hhs {
+			for _, mem := range hh.Member {
+				if err := put(c.get(mem)); err != nil {
+					return err
+				}
+			}
+		}
 	}
-	defer f.Close()
-	WriteCsv(f, hhs)
+	return nil
 }
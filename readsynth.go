@@ -1,47 +1,406 @@
 package synth
 
 import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
 	"io"
 	"log"
-	// "math/rand"
-	"os"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"bitbucket.org/SeheonKim/albatros4/model"
-	"bitbucket.org/SeheonKim/albatros4/tools"
 )
 
+// SynthData is a row of a synth file. It is the schema shared by WriteCsv and
+// ReadSynthFile: the csv tag gives the column of a field and the fields are written
+// in this order. Optional columns may be missing from a file, their fields are then 0.
+// Booleans are written as 0 or 1. All columns of a missing head are noMember.
 type SynthData struct {
 	// Mandatory attributes to construct a household
-	HHID    int
-	Home    int
-	Gem     int
-	Urb     int
-	Comp    int
-	Child   int
-	Day     int
-	SEC     int
-	Ncar    int
-	Driver  int
-	Age1    int
-	Gender1 int
-	Work1   int
-	Driver1 int
-	Age2    int
-	Gender2 int
-	Work2   int
-	Driver2 int
+	HHID   int `csv:"Hhid"`
+	Home   int `csv:"Home"`
+	Gem    int `csv:"Gem"`
+	Prov   int `csv:"Prov,optional"`
+	Urb    int `csv:"Urb"`
+	Comp   int `csv:"Comp"`
+	Child  int `csv:"Child"`
+	Day    int `csv:"Day"`
+	SEC    int `csv:"SEC"`
+	Ncar   int `csv:"Ncar"`
+	Driver int `csv:"Driver"`
 
 	// Optional attributes
-	EV   int
-	FEV  int
-	PHEV int
+	EV   int `csv:"EV,optional"`
+	FEV  int `csv:"FEV,optional"`
+	PHEV int `csv:"PHEV,optional"`
+
+	// The first two heads
+	Age1    int `csv:"Age1"`
+	Gender1 int `csv:"Gender1"`
+	Work1   int `csv:"Work1"`
+	Driver1 int `csv:"Driver1"`
+	Age2    int `csv:"Age2"`
+	Gender2 int `csv:"Gender2"`
+	Work2   int `csv:"Work2"`
+	Driver2 int `csv:"Driver2"`
+}
+
+// noMember is the value of all columns of a head that is not present
+const noMember = 999999
+
+// synthColumn is a column of a synth file
+type synthColumn struct {
+	name     string
+	field    int // Index of the SynthData field
+	optional bool
+}
+
+// synthColumns are the columns of a synth file in the order of SynthData
+var synthColumns = func() (columns []synthColumn) {
+	t := reflect.TypeOf(SynthData{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("csv"), ",")
+		columns = append(columns, synthColumn{tag[0], i, len(tag) > 1 && tag[1] == "optional"})
+	}
+	return
+}()
+
+// newSynthData returns the row of a household. Only the first two heads are included.
+func newSynthData(hh *model.Household) *SynthData {
+	r := &SynthData{
+		HHID:    hh.ID,
+		Home:    int(hh.Home),
+		Gem:     hh.WoGem,
+		Prov:    hh.Prov,
+		Urb:     int(hh.Urb),
+		Comp:    int(hh.Comp),
+		Child:   int(hh.Child),
+		Day:     int(hh.Day),
+		SEC:     int(hh.Sec),
+		Ncar:    int(hh.NumCars),
+		Driver:  hh.Driver,
+		EV:      int(flag(hh.EV)),
+		FEV:     int(flag(hh.FEV)),
+		PHEV:    int(flag(hh.PHEV)),
+		Age1:    noMember,
+		Gender1: noMember,
+		Work1:   noMember,
+		Driver1: noMember,
+		Age2:    noMember,
+		Gender2: noMember,
+		Work2:   noMember,
+		Driver2: noMember,
+	}
+
+	heads := 0
+	for _, mem := range hh.Member {
+		if !mem.Head {
+			continue // Other members are written by WritePersonsCsv
+		}
+		heads++
+		if heads == 1 {
+			r.Age1 = int(mem.Age)
+			r.Gender1 = int(mem.Gender)
+			r.Work1 = int(mem.Work)
+			r.Driver1 = int(flag(mem.IsDriver))
+		} else if heads == 2 {
+			r.Age2 = int(mem.Age)
+			r.Gender2 = int(mem.Gender)
+			r.Work2 = int(mem.Work)
+			r.Driver2 = int(flag(mem.IsDriver))
+		}
+	}
+	return r
+}
+
+// record returns the values of the row in the order of synthColumns
+func (r *SynthData) record() []string {
+	v := reflect.ValueOf(r).Elem()
+	record := make([]string, len(synthColumns))
+	for i, c := range synthColumns {
+		record[i] = strconv.FormatInt(v.Field(c.field).Int(), 10)
+	}
+	return record
+}
+
+// household creates the household of a row with its heads as members
+func (r *SynthData) household() *model.Household {
+	hh := model.NewHousehold()
+	hh.ID = r.HHID
+	hh.Home = model.Location(r.Home)
+	hh.WoGem = r.Gem
+	hh.Prov = r.Prov
+	hh.Urb = model.Urb(r.Urb)
+	hh.Comp = model.Comp(r.Comp)
+	hh.Child = model.Child(r.Child)
+	hh.Day = model.Day(r.Day)
+	hh.Sec = model.Sec(r.SEC)
+	hh.NumCars = int8(r.Ncar)
+	hh.Driver = r.Driver
+
+	// Ownership Electric Vehicle
+	// Extension
+	hh.EV = r.EV == 1
+	hh.FEV = r.FEV == 1
+	hh.PHEV = r.PHEV == 1
+
+	heads := []struct{ age, gender, work, driver int }{
+		{r.Age1, r.Gender1, r.Work1, r.Driver1},
+		{r.Age2, r.Gender2, r.Work2, r.Driver2},
+	}
+	for _, h := range heads {
+		if h.age == noMember {
+			continue
+		}
+		mem := model.NewPerson()
+		mem.ID = len(hh.Member) + 1
+		mem.Head = true
+		mem.Gender = model.Gender(h.gender)
+		mem.Age = model.Age(h.age)
+		mem.Work = model.Work(h.work)
+		mem.IsDriver = h.driver == 1
+		if mem.Age > hh.MaxAge {
+			hh.MaxAge = mem.Age
+		}
+		hh.Member = append(hh.Member, mem)
+	}
+	return hh
+}
+
+// NoMemberPolicy defines how a synth file row without a first head is read, like the
+// rows of institutional or child-only households.
+type NoMemberPolicy string
+
+const (
+	// NoMemberPanic stops reading with a panic, this is the default
+	NoMemberPanic NoMemberPolicy = "panic"
+	// NoMemberSkip skips the row with a warning
+	NoMemberSkip NoMemberPolicy = "skip"
+	// NoMemberCollect skips the row and adds an error to SynthReadSummary.Errors
+	NoMemberCollect NoMemberPolicy = "collect"
+	// NoMemberLoad loads the row as a household without members. When the row has
+	// a second head, that head is the only member.
+	NoMemberLoad NoMemberPolicy = "load"
+)
+
+// SynthReadOptions are the options for reading a synth file
+type SynthReadOptions struct {
+	NoMember NoMemberPolicy
+	Filter   *HouseholdFilter // Only households matching the filter are returned, nil for all
+
+	// Sample is the fraction of the households that is returned, in a reproducible
+	// sample determined by SampleSeed. 0 returns all households.
+	Sample     float64
+	SampleSeed int64
+}
+
+// SynthReadSummary summarises the rows read from a synth file. It is complete when the
+// channel of households is closed.
+type SynthReadSummary struct {
+	Rows       int     // Rows read
+	Households int     // Households returned
+	NoMember   int     // Rows without a first head
+	Filtered   int     // Households not matching the filter or not in the sample
+	Errors     []error // Errors collected by NoMemberCollect
+}
+
+// synthReader reads the rows of a synth file
+type synthReader struct {
+	filename      string
+	file          io.ReadCloser
+	r             *csv.Reader
+	positions     []int    // Position of each of the synthColumns, -1 when missing
+	attrs         []string // The extra columns
+	attrPositions []int
+	options       SynthReadOptions
+	summary       SynthReadSummary
+}
+
+// newSynthReader opens a synth file and matches its header with the synthColumns.
+// Columns that are not in the schema are extra columns.
+func newSynthReader(filename string, options SynthReadOptions) *synthReader {
+	switch options.NoMember {
+	case "":
+		options.NoMember = NoMemberPanic
+	case NoMemberPanic, NoMemberSkip, NoMemberCollect, NoMemberLoad:
+	default:
+		log.Panicln("Unknown no member policy", options.NoMember)
+	}
+	if options.Sample < 0 || options.Sample > 1 {
+		log.Panicln("Sample fraction", options.Sample, "is not between 0 and 1")
+	}
+
+	file, err := openFile(filename)
+	if err != nil {
+		log.Panicln("Error reading synth file:", err)
+	}
+	sr := &synthReader{filename: filename, file: file, r: csv.NewReader(file), options: options}
+	sr.r.Comma = detectComma(filename)
+	sr.r.ReuseRecord = true
+	header, err := sr.r.Read()
+	if err != nil {
+		log.Panicln("Error reading synth file header:", err)
+	}
+
+	sr.positions = make([]int, len(synthColumns))
+	known := make(map[int]bool)
+	for i, c := range synthColumns {
+		if sr.positions[i] = columnIndex(header, c.name); sr.positions[i] == -1 && !c.optional {
+			log.Panicln("Synth file", filename, "does not have column", c.name)
+		}
+		known[sr.positions[i]] = true
+	}
+	for i, h := range header {
+		if !known[i] {
+			sr.attrs = append(sr.attrs, strings.TrimSpace(h))
+			sr.attrPositions = append(sr.attrPositions, i)
+		}
+	}
+	return sr
+}
+
+// read reads all rows and closes the file. For every row emit is called with the
+// household and the record of the row, which is only valid during the call.
+func (sr *synthReader) read(emit func(hh *model.Household, record []string)) {
+	defer sr.file.Close()
+
+	line := 1
+	for {
+		record, err := sr.r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		line++
+
+		data := new(SynthData)
+		v := reflect.ValueOf(data).Elem()
+		for i, c := range synthColumns {
+			if sr.positions[i] == -1 {
+				continue
+			}
+			x, err := strconv.Atoi(strings.TrimSpace(record[sr.positions[i]]))
+			if err != nil {
+				log.Panicf("Invalid value %q in column %s on line %d of synth file", record[sr.positions[i]], c.name, line)
+			}
+			v.Field(c.field).SetInt(int64(x))
+		}
+
+		sr.summary.Rows++
+
+		if data.Age1 == noMember {
+			sr.summary.NoMember++
+			switch sr.options.NoMember {
+			case NoMemberSkip:
+				log.Println("Skipping household", data.HHID, "on line", line, "without a first household member")
+				continue
+			case NoMemberCollect:
+				sr.summary.Errors = append(sr.summary.Errors, fmt.Errorf("household %d on line %d of %s does not have a first household member", data.HHID, line, sr.filename))
+				continue
+			case NoMemberLoad:
+			default:
+				log.Panicln("Household ", data.HHID, " does not have any household member")
+			}
+		}
+
+		// Fill up hh
+		hh := data.household()
+		if !sr.options.keep(hh) {
+			sr.summary.Filtered++
+			continue
+		}
+		sr.summary.Households++
+		emit(hh, record)
+	}
+
+	if sr.summary.NoMember > 0 {
+		log.Printf("%d of %d households in %s do not have a first household member (%s)", sr.summary.NoMember, sr.summary.Rows, sr.filename, sr.options.NoMember)
+	}
 }
 
+// ReadSynthFile reads a synth file written by WriteCsv or by CsvFormat or TsvFormat.
+// The delimiter is taken from the header, so a .txt file can be written by WriteCsvFile
+// with commas or by TsvFormat with tabs. The columns are matched by the header, so they
+// may be in any order and optional columns may be missing. Extra columns are ignored,
+// use ReadSynthFileWithAttrs to keep them. A row without a first head stops reading with a panic, use
+// ReadSynthFileWithOptions for another policy. The households only have their heads as
+// members. A file ending in .gz or .zst is decompressed while reading.
 func ReadSynthFile(filename string) <-chan *model.Household {
-	file, err := os.Open(filename)
+	hhs, _ := ReadSynthFileWithOptions(filename, SynthReadOptions{})
+	return hhs
+}
+
+// ReadSynthFileWithOptions reads a synth file like ReadSynthFile with the given options.
+// The summary is complete when the channel of households is closed.
+func ReadSynthFileWithOptions(filename string, options SynthReadOptions) (<-chan *model.Household, *SynthReadSummary) {
+	sr := newSynthReader(filename, options)
+	hhs := make(chan *model.Household)
+
+	go func() {
+		defer close(hhs)
+		sr.read(func(hh *model.Household, _ []string) {
+			hhs <- hh
+		})
+	}()
+
+	return hhs, &sr.summary
+}
+
+// ExtHousehold is a household with the extension attributes of a synth file. An
+// extension attribute is a column that is not part of SynthData, such as a custom
+// variable of a project.
+type ExtHousehold struct {
+	*model.Household
+	Attrs map[string]string // The value of every extra column by column name
+}
+
+// ReadSynthFileWithAttrs reads a synth file like ReadSynthFile and keeps the values of
+// the extra columns as extension attributes. It returns the names of the extra columns
+// in file order, which can be passed to WriteCsvWithAttrs to write them back.
+func ReadSynthFileWithAttrs(filename string) ([]string, <-chan *ExtHousehold) {
+	attrs, hhs, _ := ReadSynthFileWithAttrsAndOptions(filename, SynthReadOptions{})
+	return attrs, hhs
+}
+
+// ReadSynthFileWithAttrsAndOptions reads a synth file like ReadSynthFileWithAttrs with the
+// given options, such as a no member policy, filter or sample. The summary is complete
+// when the channel of households is closed.
+func ReadSynthFileWithAttrsAndOptions(filename string, options SynthReadOptions) ([]string, <-chan *ExtHousehold, *SynthReadSummary) {
+	sr := newSynthReader(filename, options)
+	hhs := make(chan *ExtHousehold)
+
+	go func() {
+		defer close(hhs)
+		sr.read(func(hh *model.Household, record []string) {
+			ext := &ExtHousehold{hh, make(map[string]string, len(sr.attrs))}
+			for i, attr := range sr.attrs {
+				ext.Attrs[attr] = record[sr.attrPositions[i]]
+			}
+			hhs <- ext
+		})
+	}()
+
+	return sr.attrs, hhs, &sr.summary
+}
+
+// ReadSynthBinaryFile reads a population written by BinaryWriter. A file ending in
+// .gz or .zst is decompressed while reading.
+func ReadSynthBinaryFile(filename string) <-chan *model.Household {
+	file, err := openFile(filename)
 	if err != nil {
 		log.Panicln("Error reading synth file:", err)
 	}
+	r := bufio.NewReader(file)
+
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != binaryMagic {
+		log.Panicln("File", filename, "is not a binary synth file of this version")
+	}
 
 	hhs := make(chan *model.Household)
 
@@ -49,78 +408,83 @@ func ReadSynthFile(filename string) <-chan *model.Household {
 		defer file.Close()
 		defer close(hhs)
 
-		csv := tools.NewCsvReader(file, ',')
-		for {
-			record := new(SynthData)
-			err := csv.Read(record)
+		get := func() int64 {
+			v, err := binary.ReadVarint(r)
 			if err == io.EOF {
-				break
+				err = io.ErrUnexpectedEOF
 			}
 			if err != nil {
-				log.Panic(err)
+				log.Panicln("Error reading synth file:", err)
 			}
+			return v
+		}
+		count := func() int {
+			n := get()
+			if n < 0 {
+				log.Panicln("Invalid count", n, "in synth file")
+			}
+			return int(n)
+		}
 
-			hh := model.NewHousehold()
-			hh.ID = record.HHID
-			hh.Home = model.Location(record.Home)
-			hh.WoGem = record.Gem
-			hh.Urb = model.Urb(record.Urb)
-			hh.Comp = model.Comp(record.Comp)
-			hh.Child = model.Child(record.Child)
-			hh.Day = model.Day(record.Day)
-			hh.Sec = model.Sec(record.SEC)
-			hh.NumCars = int8(record.Ncar)
-			hh.Driver = record.Driver
-
-			// Ownership Electric Vehicle
-			// Extension
-			hh.EV = record.EV == 1
-			hh.FEV = record.FEV == 1
-			hh.PHEV = record.PHEV == 1
-
-			// pFEV := float64(0.16)
-			// if float64(rand.Intn(100)/100) > pFEV {
-			// 	hh.FEV = true
-			// } else {
-			// 	hh.PHEV = true
-			// }
-			
-			if record.Age1 != 999999 {
-				mem1 := model.NewPerson()
-				mem1.ID = len(hh.Member) + 1
-				mem1.Head = true
-				mem1.Gender = model.Gender(record.Gender1)
-				mem1.Age = model.Age(record.Age1)
-				hh.MaxAge = mem1.Age
-				if record.Driver1 == 1 {
-					mem1.IsDriver = true
-				}
-				mem1.Work = model.Work(record.Work1)
-
-				hh.Member = append(hh.Member, mem1)
-			} else {
-				log.Panicln("Household ", hh.ID, " does not have any household member")
+		for {
+			n := count()
+			if n == 0 {
+				break
 			}
 
-			if record.Age2 != 999999 {
-				mem2 := model.NewPerson()
-				mem2.ID = len(hh.Member) + 1
-				mem2.Head = true
-				mem2.Gender = model.Gender(record.Gender2)
-				mem2.Age = model.Age(record.Age2)
-				if mem2.Age > hh.MaxAge {
-					hh.MaxAge = mem2.Age
+			chunk := make([]*model.Household, n)
+			for i := range chunk {
+				chunk[i] = model.NewHousehold()
+			}
+			for _, c := range binaryHouseholdColumns {
+				for _, hh := range chunk {
+					c.set(hh, get())
 				}
-				if record.Driver2 == 1 {
-					mem2.IsDriver = true
+			}
+			for _, hh := range chunk {
+				for i, members := 0, count(); i < members; i++ {
+					hh.Member = append(hh.Member, model.NewPerson())
+				}
+			}
+			for _, c := range binaryPersonColumns {
+				for _, hh := range chunk {
+					for _, mem := range hh.Member {
+						c.set(mem, get())
+					}
 				}
-				mem2.Work = model.Work(record.Work2)
+			}
 
-				hh.Member = append(hh.Member, mem2)
+			for _, hh := range chunk {
+				for _, mem := range hh.Member {
+					if mem.Head && mem.Age > hh.MaxAge {
+						hh.MaxAge = mem.Age
+					}
+				}
+				hhs <- hh
 			}
+		}
+	}()
 
-			// Fill up hh
-			hhs <- hh
+	return hhs
+}
+
+// ReadSynthFiles reads the households of all files, one file after the other, such as the
+// shards written by WriteShardedFiles. Files ending in .synb, optionally followed by .gz
+// or .zst, are read by ReadSynthBinaryFile and all other files by ReadSynthFile.
+func ReadSynthFiles(filenames ...string) <-chan *model.Household {
+	hhs := make(chan *model.Household)
+
+	go func() {
+		defer close(hhs)
+
+		for _, filename := range filenames {
+			read := ReadSynthFile
+			if w, _ := WriterFor(filename); w == BinaryFormat {
+				read = ReadSynthBinaryFile
+			}
+			for hh := range read(filename) {
+				hhs <- hh
+			}
 		}
 	}()
 
@@ -0,0 +1,207 @@
+package synth
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// randomHousehold returns a household with one or two heads, followed by up to three
+// other members, and all attributes of a synth file set at random
+func randomHousehold(rng *rand.Rand, id int) *model.Household {
+	hh := model.NewHousehold()
+	hh.ID = id
+	hh.Home = model.Location(1000 + rng.Intn(9000))
+	hh.WoGem = rng.Intn(400)
+	hh.Prov = rng.Intn(13)
+	hh.Urb = model.Urb(rng.Intn(5))
+	hh.Comp = model.Comp(rng.Intn(5))
+	hh.Child = model.Child(rng.Intn(3))
+	hh.Day = model.Day(rng.Intn(7))
+	hh.Sec = model.Sec(rng.Intn(5))
+	hh.NumCars = int8(rng.Intn(3))
+	hh.EV = rng.Intn(2) == 1
+	hh.FEV = rng.Intn(2) == 1
+	hh.PHEV = rng.Intn(2) == 1
+
+	heads := 1 + rng.Intn(2)
+	others := rng.Intn(4)
+	for i := 0; i < heads+others; i++ {
+		mem := model.NewPerson()
+		mem.ID = i + 1
+		mem.Head = i < heads
+		mem.Gender = model.Gender(rng.Intn(2))
+		mem.Age = model.Age(rng.Intn(5))
+		mem.Work = model.Work(rng.Intn(3))
+		mem.IsDriver = rng.Intn(2) == 1
+		if mem.Head && mem.Age > hh.MaxAge {
+			hh.MaxAge = mem.Age
+		}
+		hh.Member = append(hh.Member, mem)
+	}
+	if hh.Member[0].IsDriver {
+		hh.Driver = 1
+	}
+	return hh
+}
+
+func randomHouseholds(seed int64, n int) []*model.Household {
+	rng := rand.New(rand.NewSource(seed))
+	hhs := make([]*model.Household, n)
+	for i := range hhs {
+		hhs[i] = randomHousehold(rng, i+1)
+	}
+	return hhs
+}
+
+func send(hhs []*model.Household) <-chan *model.Household {
+	c := make(chan *model.Household, len(hhs))
+	for _, hh := range hhs {
+		c <- hh
+	}
+	close(c)
+	return c
+}
+
+func receive(c <-chan *model.Household) (hhs []*model.Household) {
+	for hh := range c {
+		hhs = append(hhs, hh)
+	}
+	return
+}
+
+// equalHouseholds compares every attribute of a synth file. Unless allMembers is set
+// only the heads are compared, because synth files hold no other members.
+func equalHouseholds(t *testing.T, name string, want, got []*model.Household, allMembers bool) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: read %d households, want %d", name, len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.ID != w.ID || g.Home != w.Home || g.WoGem != w.WoGem || g.Prov != w.Prov ||
+			g.Urb != w.Urb || g.Comp != w.Comp || g.Child != w.Child || g.Day != w.Day ||
+			g.Sec != w.Sec || g.NumCars != w.NumCars || g.Driver != w.Driver ||
+			g.EV != w.EV || g.FEV != w.FEV || g.PHEV != w.PHEV || g.MaxAge != w.MaxAge {
+			t.Errorf("%s: household %d is %+v, want %+v", name, w.ID, *g, *w)
+			continue
+		}
+
+		members := w.Member
+		if !allMembers {
+			members = nil
+			for _, mem := range w.Member {
+				if mem.Head {
+					members = append(members, mem)
+				}
+			}
+		}
+		if len(g.Member) != len(members) {
+			t.Errorf("%s: household %d has %d members, want %d", name, w.ID, len(g.Member), len(members))
+			continue
+		}
+		for j, mem := range members {
+			gm := g.Member[j]
+			if gm.ID != mem.ID || gm.Head != mem.Head || gm.Gender != mem.Gender || gm.Age != mem.Age ||
+				gm.Work != mem.Work || gm.IsDriver != mem.IsDriver {
+				t.Errorf("%s: member %d of household %d is %+v, want %+v", name, j+1, w.ID, *gm, *mem)
+			}
+		}
+	}
+}
+
+func TestWriteCsvReadSynthFile(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		hhs := randomHouseholds(seed, 100)
+
+		var buf bytes.Buffer
+		if n, err := WriteCsv(&buf, send(hhs)); err != nil || n != len(hhs) {
+			t.Fatalf("WriteCsv wrote %d households with error %v, want %d", n, err, len(hhs))
+		}
+		filename := filepath.Join(t.TempDir(), "population.csv")
+		if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		equalHouseholds(t, "WriteCsv", hhs, receive(ReadSynthFile(filename)), false)
+	}
+
+	// WriteCsvFile writes commas to a .txt file, which TsvFormat writes with tabs
+	hhs := randomHouseholds(11, 100)
+	filename := filepath.Join(t.TempDir(), "population.txt")
+	if n, err := WriteCsvFile(filename, send(hhs)); err != nil || n != len(hhs) {
+		t.Fatalf("WriteCsvFile wrote %d households with error %v, want %d", n, err, len(hhs))
+	}
+	equalHouseholds(t, "WriteCsvFile", hhs, receive(ReadSynthFile(filename)), false)
+}
+
+func TestWritePopulationFileRoundTrip(t *testing.T) {
+	tests := []struct {
+		filename   string
+		allMembers bool
+	}{
+		{"population.csv", false},
+		{"population.tsv", false},
+		{"population.txt", false},
+		{"population.csv.gz", false},
+		{"population.tsv.zst", false},
+		{"population.synb", true},
+		{"population.synb.gz", true},
+	}
+
+	hhs := randomHouseholds(42, 5000)
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), test.filename)
+		if n, err := WritePopulationFile(filename, send(hhs)); err != nil || n != len(hhs) {
+			t.Fatalf("%s: wrote %d households with error %v, want %d", test.filename, n, err, len(hhs))
+		}
+		equalHouseholds(t, test.filename, hhs, receive(ReadSynthFiles(filename)), test.allMembers)
+	}
+}
+
+func TestReadSynthFileWithAttrsAndOptions(t *testing.T) {
+	hhs := randomHouseholds(5, 200)
+	ext := make(chan *ExtHousehold, len(hhs))
+	for _, hh := range hhs {
+		ext <- &ExtHousehold{hh, map[string]string{"Tenure": fmt.Sprint(hh.ID % 3)}}
+	}
+	close(ext)
+
+	var buf bytes.Buffer
+	if _, err := WriteCsvWithAttrs(&buf, []string{"Tenure"}, ext); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "population.csv")
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := &HouseholdFilter{Prov: []int{1, 2, 3}}
+	attrs, read, summary := ReadSynthFileWithAttrsAndOptions(filename, SynthReadOptions{Filter: filter})
+	if len(attrs) != 1 || attrs[0] != "Tenure" {
+		t.Errorf("attributes are %v, want [Tenure]", attrs)
+	}
+
+	var want []*model.Household
+	for _, hh := range hhs {
+		if filter.Match(hh) {
+			want = append(want, hh)
+		}
+	}
+	var got []*model.Household
+	for hh := range read {
+		if tenure := fmt.Sprint(hh.ID % 3); hh.Attrs["Tenure"] != tenure {
+			t.Errorf("household %d has tenure %q, want %s", hh.ID, hh.Attrs["Tenure"], tenure)
+		}
+		got = append(got, hh.Household)
+	}
+	equalHouseholds(t, "filtered", want, got, false)
+	if summary.Rows != len(hhs) || summary.Filtered != len(hhs)-len(want) {
+		t.Errorf("summary is %+v, want %d rows of which %d filtered", *summary, len(hhs), len(hhs)-len(want))
+	}
+}
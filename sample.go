@@ -0,0 +1,125 @@
+package synth
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"bitbucket.org/SeheonKim/albatros4/mat"
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// seedPool contains the seed households per spatial segment and per cell of
+// the multiway table. It is used to draw real households into a subzone.
+type seedPool struct {
+	dims     []int
+	segments []map[int][]*SeedHousehold
+	national map[int][]*SeedHousehold
+}
+
+// cellKey returns a unique key for a multiway table index
+func cellKey(index []int, dims []int) (key int) {
+	for i := range index {
+		key = key*dims[i] + index[i]
+	}
+	return
+}
+
+// readSeedPool reads the seed households and puts every household in the cell of the
+// multiway table its first head falls in.
+func readSeedPool(source SeedSource, indepVars []string, segmentation *SpatialSegmentation) *seedPool {
+	log.Println("Reading seed households")
+	start := time.Now()
+
+	p := &seedPool{
+		dims:     append([]int{15, 23}, VarLevels(indepVars)...),
+		segments: make([]map[int][]*SeedHousehold, segmentation.N),
+		national: make(map[int][]*SeedHousehold),
+	}
+	for i := range p.segments {
+		p.segments[i] = make(map[int][]*SeedHousehold)
+	}
+
+	n := 0
+	for hh := range source.Households() {
+		segment := segmentation.Segment(int(hh.Urb), hh.Prov)
+		if segment == -1 {
+			continue
+		}
+		for i, m := range classifyHousehold(hh.Household) {
+			if !hh.Member[i].Head {
+				continue
+			}
+			if m.AgeWorkHousehold.U != -1 {
+				key := cellKey(m.Index(indepVars), p.dims)
+				p.segments[segment][key] = append(p.segments[segment][key], hh)
+				p.national[key] = append(p.national[key], hh)
+				n++
+			}
+			break
+		}
+	}
+
+	log.Println("Done reading", n, "seed households in", time.Since(start))
+	return p
+}
+
+// draw returns a random seed household of the spatial segment in the given cell with
+// a probability proportional to its weight. If the segment has no households in
+// that cell it is drawn from all segments.
+// It returns nil when there are no seed households in the cell at all.
+func (p *seedPool) draw(segment int, index mat.Index) *model.Household {
+	key := cellKey(index, p.dims)
+	hhs := p.segments[segment][key]
+	if len(hhs) == 0 {
+		hhs = p.national[key]
+	}
+	if len(hhs) == 0 {
+		return nil
+	}
+
+	weights := make([]float64, len(hhs))
+	for i, hh := range hhs {
+		weights[i] = hh.Weight
+	}
+	if sum(weights) == 0 {
+		return hhs[rand.Intn(len(hhs))].Household
+	}
+	return hhs[drawCategory(weights)].Household
+}
+
+// sampleSubzone creates the households of a subzone by drawing for every count in the fitted
+// multiway table a seed household from the same cell. The households are given ids starting
+// at hhid and passed to emit, which places and sends them. It returns the next household id
+// and the number of households that could not be drawn, because their cell has no seed.
+func sampleSubzone(result *subzoneResult, seeds *seedPool, hhid int, emit func(*model.Household)) (int, int) {
+	segment := result.segment
+	missing := 0
+
+	index := make(mat.Index, len(result.fittedMultiwayTable.Dims))
+	for {
+		count := int(result.fittedMultiwayTable.At(index...))
+		for i := 0; i < count; i++ {
+			seed := seeds.draw(segment, index)
+			if seed == nil {
+				missing += count - i
+				break
+			}
+
+			hh := seed.Clone()
+			hh.ID = hhid
+			hh.Prov = result.subzone.Prov
+			hh.Urb = model.Urb(result.subzone.Sted - 1)
+			emit(hh)
+			hhid++
+		}
+		if index.Inc(result.fittedMultiwayTable.Dims) {
+			break
+		}
+	}
+
+	if missing > 0 {
+		log.Printf("No seed households found for %d households in subzone %d", missing, result.subzone.Id)
+	}
+	return hhid, missing
+}
@@ -0,0 +1,35 @@
+package synth
+
+import (
+	"testing"
+
+	"bitbucket.org/SeheonKim/albatros4/mat"
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+func TestSampleSubzoneShortfall(t *testing.T) {
+	single := seedHousehold(1, 2, nil, person(true, model.Male, 2, 1))
+	couple := seedHousehold(2, 3, nil, person(true, model.Male, 1, 2), person(true, model.Female, 1, 0))
+	seeds := readSeedPool(sliceSeedSource{single}, nil, DefaultSpatialSegmentation)
+
+	table := mat.Zeroes(seeds.dims...)
+	strides := matStrides(table.Dims)
+	table.Vals[offset(classifyHousehold(single.Household)[0].Index(nil), strides)] = 3
+	table.Vals[offset(classifyHousehold(couple.Household)[0].Index(nil), strides)] = 2
+	result := &subzoneResult{subzone: &Subzone{Id: 5}, fittedMultiwayTable: table}
+
+	var emitted []int
+	hhid, missing := sampleSubzone(result, seeds, 10, func(hh *model.Household) {
+		emitted = append(emitted, hh.ID)
+	})
+	if len(emitted) != 3 || hhid != 13 || missing != 2 {
+		t.Errorf("emitted households %v, next id %d and %d missing, want 3 households, 13 and 2", emitted, hhid, missing)
+	}
+
+	coverage := new(coverageReport)
+	coverage.add(&Subzone{Id: 5, Huishoudens: 5})
+	coverage.shortfall(result.subzone, missing)
+	if c := coverage.coverage(); c != 0.6 {
+		t.Errorf("coverage is %v, want 0.6", c)
+	}
+}
@@ -0,0 +1,390 @@
+package synth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// SeedSource supplies the survey households the seed tables are counted from.
+// The households must use the MON 2004 coding.
+type SeedSource interface {
+	// Households returns a channel on which the seed households will be returned.
+	// You must read all households until the channel is closed.
+	Households() <-chan *SeedHousehold
+}
+
+// SeedHousehold is a survey household with its expansion weights
+type SeedHousehold struct {
+	*model.Household
+	Weight        float64   // Household expansion weight
+	PersonWeights []float64 // Person expansion weight per member, nil when the household weight applies
+}
+
+// PersonWeight returns the expansion weight of the i-th member
+func (hh *SeedHousehold) PersonWeight(i int) float64 {
+	if hh.PersonWeights == nil {
+		return hh.Weight
+	}
+	return hh.PersonWeights[i]
+}
+
+// MonSeedSource reads the seed households from a MON 2004 file. When WeightColumn
+// is given the household expansion weights are read from that column of the file,
+// matched on the household id in IDColumn. Otherwise every household has weight 1.
+// When PersonWeightColumn is given the person expansion weights are read from that
+// column, in the order of the rows of a household, which must be the order of its members.
+type MonSeedSource struct {
+	Filename           string
+	WeightColumn       string
+	PersonWeightColumn string
+	IDColumn           string
+}
+
+// Households implements SeedSource
+func (s *MonSeedSource) Households() <-chan *SeedHousehold {
+	var weights map[int]float64
+	if s.WeightColumn != "" {
+		weights = readWeights(s.Filename, s.IDColumn, s.WeightColumn)
+	}
+	var personWeights map[int][]float64
+	if s.PersonWeightColumn != "" {
+		personWeights = readColumn(s.Filename, s.IDColumn, s.PersonWeightColumn)
+	}
+
+	c := make(chan *SeedHousehold, 10)
+	go func() {
+		defer close(c)
+
+		missing, mismatched := 0, 0
+		for hh := range model.ReadMonFile(s.Filename) {
+			w := 1.0
+			if weights != nil {
+				var exists bool
+				if w, exists = weights[hh.ID]; !exists {
+					missing++
+					w = 1
+				}
+			}
+
+			seed := &SeedHousehold{Household: hh, Weight: w}
+			if personWeights != nil {
+				if pw := personWeights[hh.ID]; len(pw) == len(hh.Member) {
+					seed.PersonWeights = pw
+				} else {
+					mismatched++
+				}
+			}
+			c <- seed
+		}
+		if missing > 0 {
+			log.Println("No weight found for", missing, "mon households, using weight 1")
+		}
+		if mismatched > 0 {
+			log.Println("No person weight for every member of", mismatched, "mon households, using the household weight")
+		}
+	}()
+	return c
+}
+
+// readWeights reads the weight per household id from a delimited file with a header.
+// The delimiter is detected from the header. Households with several rows must
+// have the same weight on each row.
+func readWeights(filename, idColumn, weightColumn string) map[int]float64 {
+	weights := make(map[int]float64)
+	for hhid, ws := range readColumn(filename, idColumn, weightColumn) {
+		for _, w := range ws[1:] {
+			if w != ws[0] {
+				log.Panicln("Household", hhid, "has different weights", ws[0], "and", w)
+			}
+		}
+		weights[hhid] = ws[0]
+	}
+	return weights
+}
+
+// readColumn reads the weights in a column of a delimited file with a header, per household
+// id in the order of the rows. The delimiter is detected from the header.
+func readColumn(filename, idColumn, weightColumn string) map[int][]float64 {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Panicln("Error opening weights file:", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = detectComma(filename)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		log.Panicln("Error reading weights header:", err)
+	}
+	id, weight := columnIndex(header, idColumn), columnIndex(header, weightColumn)
+	if id == -1 || weight == -1 {
+		log.Panicln("Weights file", filename, "does not have columns", idColumn, "and", weightColumn)
+	}
+
+	weights := make(map[int][]float64)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panicln("Error reading weights:", err)
+		}
+
+		hhid, err := strconv.Atoi(strings.TrimSpace(record[id]))
+		if err != nil {
+			log.Panicln("Invalid household id in weights file:", err)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(record[weight]), 64)
+		if err != nil || w < 0 {
+			log.Panicln("Invalid weight", record[weight], "for household", hhid)
+		}
+		weights[hhid] = append(weights[hhid], w)
+	}
+	return weights
+}
+
+// detectComma returns the delimiter used in the header of a file: a tab,
+// a semicolon or otherwise a comma.
+func detectComma(filename string) rune {
+	for _, comma := range []rune{'\t', ';'} {
+		if header, err := readHeader(filename, comma); err == nil && len(header) > 1 {
+			return comma
+		}
+	}
+	return ','
+}
+
+// columnIndex returns the position of column in the header, or -1 if it is not found.
+// Header names are trimmed of spaces and compared case insensitive, an exact match
+// first. This is how tools.CsvReader matches the fields of the zipcode, spatial segment
+// and person control files, such as Subzone to the subzone column, and checkColumns
+// and the readers of the other files use it too, such that a file passes Validate
+// exactly when it can be read.
+func columnIndex(header []string, column string) int {
+	for i, h := range header {
+		if strings.TrimSpace(h) == column {
+			return i
+		}
+	}
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// seedFields are the fields a CsvSeedSource can map a column to. Household
+// fields are taken from the first row of a household.
+var seedFields = []string{"Hhid", "Prov", "Urb", "NumCars", "Sec", "Child", "Day", "Head", "Gender", "Age", "Work", "Driver"}
+
+// seedWeightFields are the optional weight fields a CsvSeedSource can map a column to
+var seedWeightFields = []string{"Weight", "PersonWeight"}
+
+// CsvSeedSource reads seed households from a delimited file with a header and one
+// row per person, like OViN or ODiN files. The rows of a household must be consecutive.
+// Columns maps every field in seedFields to the column it is read from, and
+// optionally the household Weight and the PersonWeight. Recode optionally maps
+// the values of a column to the MON 2004 coding of the field.
+type CsvSeedSource struct {
+	Filename string
+	Comma    rune
+	Columns  map[string]string
+	Recode   map[string]map[string]int
+}
+
+// ReadCsvSeedSource creates a CsvSeedSource for filename with the column mapping read
+// from mappingFilename. The mapping is a tab separated file with a header and the
+// columns Field, Column and optionally Recode. A recode is a comma separated list of
+// value=code pairs, for example "1=0,2=1".
+func ReadCsvSeedSource(filename string, comma rune, mappingFilename string) *CsvSeedSource {
+	f, err := os.Open(mappingFilename)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer f.Close()
+
+	s := &CsvSeedSource{
+		Filename: filename,
+		Comma:    comma,
+		Columns:  make(map[string]string),
+		Recode:   make(map[string]map[string]int),
+	}
+
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	r.FieldsPerRecord = -1
+	if _, err := r.Read(); err != nil { // header
+		log.Panicln("Error reading seed column mapping:", err)
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panicln("Error reading seed column mapping:", err)
+		}
+		if len(record) < 2 {
+			log.Panicln("Seed column mapping needs a field and a column, got", record)
+		}
+
+		field := strings.TrimSpace(record[0])
+		s.Columns[field] = strings.TrimSpace(record[1])
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			s.Recode[field] = make(map[string]int)
+			for _, pair := range strings.Split(record[2], ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					log.Panicln("Invalid recode", pair, "for seed field", field)
+				}
+				code, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+				if err != nil {
+					log.Panicln("Invalid recode", pair, "for seed field", field)
+				}
+				s.Recode[field][strings.TrimSpace(kv[0])] = code
+			}
+		}
+	}
+	return s
+}
+
+// validate checks that every seed field is mapped to a column of the file
+func (s *CsvSeedSource) validate() (errs []error) {
+	var columns []string
+	for _, field := range seedFields {
+		if s.Columns[field] == "" {
+			errs = append(errs, fmt.Errorf("seed field %s is not mapped to a column", field))
+			continue
+		}
+		columns = append(columns, s.Columns[field])
+	}
+	for _, field := range seedWeightFields {
+		if s.Columns[field] != "" {
+			columns = append(columns, s.Columns[field])
+		}
+	}
+	return append(errs, checkColumns("seed", s.Filename, s.Comma, columns)...)
+}
+
+// Households implements SeedSource
+func (s *CsvSeedSource) Households() <-chan *SeedHousehold {
+	c := make(chan *SeedHousehold, 10)
+
+	go func() {
+		defer close(c)
+
+		f, err := os.Open(s.Filename)
+		if err != nil {
+			log.Panicln("Error opening seed file:", err)
+		}
+		defer f.Close()
+
+		r := csv.NewReader(f)
+		r.Comma = s.Comma
+		r.ReuseRecord = true
+		header, err := r.Read()
+		if err != nil {
+			log.Panicln("Error reading seed file header:", err)
+		}
+
+		positions := make(map[string]int)
+		for _, field := range seedFields {
+			if positions[field] = columnIndex(header, s.Columns[field]); positions[field] == -1 {
+				log.Panicln("Seed file does not have column", s.Columns[field], "for field", field)
+			}
+		}
+		for _, field := range seedWeightFields {
+			if s.Columns[field] == "" {
+				continue
+			}
+			if positions[field] = columnIndex(header, s.Columns[field]); positions[field] == -1 {
+				log.Panicln("Seed file does not have column", s.Columns[field], "for field", field)
+			}
+		}
+
+		var hh *SeedHousehold
+		line := 1
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Panicln("Error reading seed file:", err)
+			}
+			line++
+
+			weight := func(field string) float64 {
+				if _, exists := positions[field]; !exists {
+					return 1
+				}
+				w, err := strconv.ParseFloat(strings.TrimSpace(record[positions[field]]), 64)
+				if err != nil || w < 0 {
+					log.Panicf("Invalid weight %q for seed field %s on line %d", record[positions[field]], field, line)
+				}
+				return w
+			}
+
+			value := func(field string) int {
+				v := strings.TrimSpace(record[positions[field]])
+				if codes, exists := s.Recode[field]; exists {
+					code, exists := codes[v]
+					if !exists {
+						log.Panicf("No recode for value %q of seed field %s on line %d", v, field, line)
+					}
+					return code
+				}
+				i, err := strconv.Atoi(v)
+				if err != nil {
+					log.Panicf("Invalid value %q for seed field %s on line %d", v, field, line)
+				}
+				return i
+			}
+
+			if id := value("Hhid"); hh == nil || hh.ID != id {
+				if hh != nil {
+					c <- hh
+				}
+				hh = &SeedHousehold{Household: model.NewHousehold(), Weight: weight("Weight")}
+				hh.ID = id
+				hh.Prov = value("Prov")
+				hh.Urb = model.Urb(value("Urb"))
+				hh.NumCars = int8(value("NumCars"))
+				hh.Sec = model.Sec(value("Sec"))
+				hh.Child = model.Child(value("Child"))
+				hh.Day = model.Day(value("Day"))
+			}
+
+			mem := model.NewPerson()
+			mem.ID = len(hh.Member) + 1
+			mem.Head = value("Head") == 1
+			mem.Gender = model.Gender(value("Gender"))
+			mem.Age = model.Age(value("Age"))
+			mem.Work = model.Work(value("Work"))
+			mem.IsDriver = value("Driver") == 1
+			if mem.Head && mem.Age > hh.MaxAge {
+				hh.MaxAge = mem.Age
+			}
+			hh.Member = append(hh.Member, mem)
+			if _, exists := positions["PersonWeight"]; exists {
+				hh.PersonWeights = append(hh.PersonWeights, weight("PersonWeight"))
+			}
+		}
+		if hh != nil {
+			c <- hh
+		}
+	}()
+
+	return c
+}
@@ -0,0 +1,122 @@
+package synth
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"bitbucket.org/SeheonKim/albatros4/tools"
+)
+
+// SpatialSegmentRule assigns a spatial segment to the subzones and seed households
+// matching its Prov, Sted and Subzone values. A value of -1 matches any value.
+// Seed households have no subzone, so rules for a specific subzone only apply to subzones.
+// The subzones of such a rule are fitted with the seed households of their SeedSegment.
+type SpatialSegmentRule struct {
+	Segment int
+	Prov    int
+	Sted    int
+	Subzone int
+}
+
+// SpatialSegmentation classifies subzones and seed households into spatial segments.
+// The first matching rule determines the segment.
+type SpatialSegmentation struct {
+	Rules []SpatialSegmentRule
+	N     int // The number of segments
+}
+
+// DefaultSpatialSegmentation is the segmentation from Albatross book 2.0 at p.38.
+// Sted in MON 2004: 1 - 5 (changed to) -> // hh.Urb: 0 - 4
+var DefaultSpatialSegmentation = NewSpatialSegmentation([]SpatialSegmentRule{
+	{Segment: 0, Prov: -1, Sted: 0, Subzone: -1},
+	{Segment: 1, Prov: -1, Sted: 1, Subzone: -1},
+	{Segment: 2, Prov: -1, Sted: 2, Subzone: -1},
+	{Segment: 4, Prov: 4, Sted: -1, Subzone: -1},
+	{Segment: 4, Prov: 9, Sted: -1, Subzone: -1},
+	{Segment: 4, Prov: 11, Sted: -1, Subzone: -1},
+	{Segment: 4, Prov: 12, Sted: -1, Subzone: -1},
+	{Segment: 3, Prov: -1, Sted: -1, Subzone: -1},
+})
+
+// NewSpatialSegmentation creates a segmentation from the given rules. The number of
+// segments is one more than the highest segment used.
+func NewSpatialSegmentation(rules []SpatialSegmentRule) *SpatialSegmentation {
+	s := &SpatialSegmentation{Rules: rules}
+	for _, r := range rules {
+		if r.Segment < 0 {
+			log.Panicln("Negative spatial segment", r.Segment, "in segmentation rules")
+		}
+		if r.Segment >= s.N {
+			s.N = r.Segment + 1
+		}
+	}
+	return s
+}
+
+// matches reports whether value matches the rule value v
+func matches(v, value int) bool {
+	return v == -1 || v == value
+}
+
+// Segment returns the spatial segment for the given sted and prov values, or
+// -1 when no rule matches.
+func (s *SpatialSegmentation) Segment(sted, prov int) int {
+	for _, r := range s.Rules {
+		if r.Subzone == -1 && matches(r.Sted, sted) && matches(r.Prov, prov) {
+			return r.Segment
+		}
+	}
+	return -1
+}
+
+// SubzoneSegment returns the spatial segment for a subzone, or -1 when no rule matches.
+func (s *SpatialSegmentation) SubzoneSegment(subzone *Subzone) int {
+	for _, r := range s.Rules {
+		if matches(r.Subzone, subzone.Id) && matches(r.Sted, subzone.Sted) && matches(r.Prov, subzone.Prov) {
+			return r.Segment
+		}
+	}
+	return -1
+}
+
+// SeedSegment returns the spatial segment whose seed households are used for a subzone,
+// or -1 when no rule matches. It is the segment of the first rule for any subzone matching
+// the subzone, which is the SubzoneSegment unless a rule for a specific subzone applies.
+func (s *SpatialSegmentation) SeedSegment(subzone *Subzone) int {
+	for _, r := range s.Rules {
+		if r.Subzone == -1 && matches(r.Sted, subzone.Sted) && matches(r.Prov, subzone.Prov) {
+			return r.Segment
+		}
+	}
+	return -1
+}
+
+// ReadSpatialSegmentation loads the segmentation rules from a tab separated file
+// with the columns Segment, Prov, Sted and Subzone.
+func ReadSpatialSegmentation(filename string) *SpatialSegmentation {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer f.Close()
+
+	var rules []SpatialSegmentRule
+	csv := tools.NewCsvReader(f, '\t')
+	for {
+		r := new(SpatialSegmentRule)
+		err := csv.Read(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panicln(err)
+		}
+		rules = append(rules, *r)
+	}
+
+	if len(rules) == 0 {
+		log.Panicln("No spatial segmentation rules found in", filename)
+	}
+	return NewSpatialSegmentation(rules)
+}
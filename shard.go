@@ -0,0 +1,259 @@
+package synth
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// ShardKey returns the shard a household is written to. Households with a negative
+// shard could not be assigned and are written to the unknown shard.
+type ShardKey func(*model.Household) int
+
+// ShardByProvince shards the households by their province
+func ShardByProvince(hh *model.Household) int {
+	return hh.Prov
+}
+
+// ShardBySpatialSegment shards the households by the spatial segment of their
+// urbanisation and province, the way the seed households are segmented. Unlike
+// ShardBySegment it only needs the household, so it also works for households that
+// are read from a synth file.
+func ShardBySpatialSegment(segmentation *SpatialSegmentation) ShardKey {
+	return func(hh *model.Household) int {
+		return segmentation.Segment(int(hh.Urb), hh.Prov)
+	}
+}
+
+// OriginKey returns the shard of a household from the subzone and spatial segment it
+// is synthesized in, see SynthesizePopulationToShards. A negative shard is the unknown shard.
+type OriginKey func(subzone, segment int) int
+
+// ShardBySubzone shards the households by the subzone they are synthesized in
+func ShardBySubzone(subzone, segment int) int {
+	return subzone
+}
+
+// ShardBySegment shards the households by the spatial segment of the subzone they are
+// synthesized in
+func ShardBySegment(subzone, segment int) int {
+	return segment
+}
+
+// ShardFilename returns the filename of a shard, which is the shard inserted before the
+// extension of filename. For example shard 3 of population.csv.gz is population.3.csv.gz
+// and the unknown shard is population.unknown.csv.gz.
+func ShardFilename(filename string, shard int) string {
+	name, compression := splitCompression(filename)
+	base := name
+	ext := ""
+	if i := strings.LastIndex(name, "."); i > strings.LastIndexAny(name, `/\`) {
+		base, ext = name[:i], name[i:]
+	}
+
+	s := fmt.Sprint(shard)
+	if shard < 0 {
+		s = "unknown"
+	}
+	return base + "." + s + ext + compression
+}
+
+// maxOpenShards is the number of shard files WriteShardedFiles writes at a time, well
+// below the common limit of 1024 open files per process
+const maxOpenShards = 256
+
+// shardedHousehold is a household with the shard it is written to
+type shardedHousehold struct {
+	hh    *model.Household
+	shard int
+}
+
+// WriteShardedFiles writes every household to the shard file of its key, named by
+// ShardFilename, with the Writer and compression selected by the extension of filename.
+// At most maxOpenShards shard files are open at a time. The households of the other
+// shards are kept in a temporary binary file, which is read again to write the next
+// shards once the open shard files are complete. It returns the number of households
+// written per shard and the first error of any shard.
+func WriteShardedFiles(filename string, key ShardKey, hhs <-chan *model.Household) (map[int]int, error) {
+	c := make(chan shardedHousehold)
+	go func() {
+		defer close(c)
+		for hh := range hhs {
+			c <- shardedHousehold{hh, key(hh)}
+		}
+	}()
+	return writeShardedFiles(filename, c)
+}
+
+// writeShardedFiles writes the households to their shard files like WriteShardedFiles
+func writeShardedFiles(filename string, hhs <-chan shardedHousehold) (map[int]int, error) {
+	if _, err := WriterFor(filename); err != nil {
+		drain(hhs)
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "shards")
+	if err != nil {
+		drain(hhs)
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	rows := make(map[int]int)
+	for pass := 0; ; pass++ {
+		spill := filepath.Join(dir, fmt.Sprint(pass, ".synb"))
+		spilled, err := writeShards(filename, hhs, rows, spill)
+		if err != nil || !spilled {
+			return rows, err
+		}
+		hhs = readSpill(spill)
+	}
+}
+
+// spillKeys returns the name of the file with the shards of the households in spill
+func spillKeys(spill string) string {
+	return spill + ".keys"
+}
+
+// writeSpill writes the households to the binary file spill and their shards to the
+// spillKeys file, a varint per household. It returns the number of households written.
+func writeSpill(spill string, hhs <-chan shardedHousehold) (int, error) {
+	keys, err := os.Create(spillKeys(spill))
+	if err != nil {
+		drain(hhs)
+		return 0, err
+	}
+	defer keys.Close()
+	buf := bufio.NewWriter(keys)
+
+	type result struct {
+		rows int
+		err  error
+	}
+	c := make(chan *model.Household)
+	done := make(chan result)
+	go func() {
+		rows, err := writeFile(spill, c, BinaryFormat.Write)
+		done <- result{rows, err}
+	}()
+
+	b := make([]byte, binary.MaxVarintLen64)
+	var kerr error
+	for sh := range hhs {
+		if _, err := buf.Write(b[:binary.PutVarint(b, int64(sh.shard))]); err != nil && kerr == nil {
+			kerr = err
+		}
+		c <- sh.hh
+	}
+	close(c)
+
+	r := <-done
+	if err := buf.Flush(); err != nil && kerr == nil {
+		kerr = err
+	}
+	if r.err != nil {
+		return r.rows, r.err
+	}
+	return r.rows, kerr
+}
+
+// readSpill reads the households written by writeSpill with their shards
+func readSpill(spill string) <-chan shardedHousehold {
+	keys, err := os.Open(spillKeys(spill))
+	if err != nil {
+		log.Panicln("Error reading temporary shard file:", err)
+	}
+	r := bufio.NewReader(keys)
+	hhs := ReadSynthBinaryFile(spill)
+
+	c := make(chan shardedHousehold)
+	go func() {
+		defer keys.Close()
+		defer close(c)
+		for hh := range hhs {
+			shard, err := binary.ReadVarint(r)
+			if err != nil {
+				log.Panicln("Error reading temporary shard file:", err)
+			}
+			c <- shardedHousehold{hh, int(shard)}
+		}
+	}()
+	return c
+}
+
+// writeShards writes the households of the first maxOpenShards shards to their shard
+// files and adds their number of households to rows. The households of the other shards
+// are written to the temporary file spill, it reports whether there were any.
+func writeShards(filename string, hhs <-chan shardedHousehold, rows map[int]int, spill string) (bool, error) {
+	type shardResult struct {
+		shard int
+		rows  int
+		err   error
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan shardResult)
+	shards := make(map[int]chan<- *model.Household)
+	start := func(shard int) chan<- *model.Household {
+		c := make(chan *model.Household, 10)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := WritePopulationFile(ShardFilename(filename, shard), c)
+			results <- shardResult{shard, rows, err}
+		}()
+		return c
+	}
+
+	var spillRows int
+	var spillErr error
+	spillDone := make(chan struct{})
+	spilled := make(chan shardedHousehold, 10)
+	go func() {
+		defer close(spillDone)
+		spillRows, spillErr = writeSpill(spill, spilled)
+	}()
+
+	go func() {
+		for sh := range hhs {
+			shard := sh.shard
+			if shard < 0 {
+				shard = -1
+			}
+			c, exists := shards[shard]
+			if !exists && len(shards) < maxOpenShards {
+				c = start(shard)
+				shards[shard] = c
+			} else if !exists {
+				spilled <- shardedHousehold{sh.hh, shard}
+				continue
+			}
+			c <- sh.hh
+		}
+		close(spilled)
+		for _, c := range shards {
+			close(c)
+		}
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+	for r := range results {
+		rows[r.shard] = r.rows
+		if r.err != nil && err == nil {
+			err = fmt.Errorf("shard %d: %v", r.shard, r.err)
+		}
+	}
+	<-spillDone
+	if spillErr != nil && err == nil {
+		err = fmt.Errorf("temporary shard file: %v", spillErr)
+	}
+	return spillRows > 0, err
+}
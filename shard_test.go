@@ -0,0 +1,41 @@
+package synth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+func TestWriteShardedFilesManyShards(t *testing.T) {
+	const shards = 2*maxOpenShards + 10
+	hhs := randomHouseholds(7, 5*shards)
+	key := func(hh *model.Household) int {
+		if hh.ID%shards == 0 {
+			return -1 // The unknown shard
+		}
+		return hh.ID % shards
+	}
+
+	filename := filepath.Join(t.TempDir(), "population.csv.gz")
+	rows, err := WriteShardedFiles(filename, key, send(hhs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != shards {
+		t.Fatalf("wrote %d shards, want %d", len(rows), shards)
+	}
+
+	for shard, n := range rows {
+		var want []*model.Household
+		for _, hh := range hhs {
+			if key(hh) == shard {
+				want = append(want, hh)
+			}
+		}
+		if n != len(want) {
+			t.Errorf("shard %d has %d households, want %d", shard, n, len(want))
+		}
+		equalHouseholds(t, ShardFilename(filename, shard), want, receive(ReadSynthFile(ShardFilename(filename, shard))), false)
+	}
+}
@@ -0,0 +1,100 @@
+package synth
+
+import (
+	"log"
+
+	"bitbucket.org/SeheonKim/albatros4/mat"
+)
+
+// SmoothingMethod defines how the count tables of sparse spatial segments borrow
+// seed counts from the other segments.
+type SmoothingMethod string
+
+const (
+	// SmoothNone uses the counts of the spatial segment only
+	SmoothNone SmoothingMethod = "none"
+	// SmoothPool mixes the counts of the segment with the counts of all segments
+	// scaled to the total of the segment. Weight is the share of the national counts.
+	SmoothPool SmoothingMethod = "pool"
+	// SmoothPrior adds Weight to every cell that has a count in any segment, such that
+	// combinations that exist nationally are not structural zeroes in a segment.
+	SmoothPrior SmoothingMethod = "prior"
+)
+
+// SeedSmoothing defines the smoothing of the seed count tables
+type SeedSmoothing struct {
+	Method SmoothingMethod
+	Weight float64
+}
+
+// smoothCountTables smooths the count tables of all spatial segments using the
+// sum of all count tables as national table.
+func smoothCountTables(countTables []*countTable, smoothing SeedSmoothing) {
+	if smoothing.Method == "" || smoothing.Method == SmoothNone {
+		return
+	}
+
+	national := func(table func(*countTable) *mat.Mat) *mat.Mat {
+		n := table(countTables[0]).Clone()
+		for _, t := range countTables[1:] {
+			for i, v := range table(t).Vals {
+				n.Vals[i] += v
+			}
+		}
+		return n
+	}
+
+	tables := []func(*countTable) *mat.Mat{
+		func(t *countTable) *mat.Mat { return t.ageHouseholdTable },
+		func(t *countTable) *mat.Mat { return t.workHouseholdTable },
+		func(t *countTable) *mat.Mat { return t.multiwayTable },
+	}
+	for _, table := range tables {
+		n := national(table)
+		for _, t := range countTables {
+			smooth(table(t).Vals, n.Vals, smoothing)
+		}
+	}
+
+	// The age work household table must stay the marginal of the multiway table
+	for _, t := range countTables {
+		t.ageWorkHouseholdTable = multiwayMarginal(t.multiwayTable, t.ageWorkHouseholdTable.Dims)
+	}
+}
+
+// smooth smooths the vals of a segment table with the vals of the national table
+func smooth(vals, national []float64, smoothing SeedSmoothing) {
+	switch smoothing.Method {
+	case SmoothPool:
+		scale := 0.0
+		if s := sum(national); s > 0 {
+			scale = sum(vals) / s
+		}
+		for i := range vals {
+			vals[i] = (1-smoothing.Weight)*vals[i] + smoothing.Weight*scale*national[i]
+		}
+	case SmoothPrior:
+		for i := range vals {
+			if national[i] > 0 {
+				vals[i] += smoothing.Weight
+			}
+		}
+	default:
+		log.Panicln("Unknown seed smoothing method", smoothing.Method)
+	}
+}
+
+// multiwayMarginal returns the sum of the multiway table over all dimensions
+// except the first two, as a table with the given dimensions.
+func multiwayMarginal(multiway *mat.Mat, dims []int) *mat.Mat {
+	m := mat.Zeroes(dims...)
+	strides := matStrides(dims)
+	index := make(mat.Index, len(multiway.Dims))
+	for {
+		m.Vals[offset(index[:2], strides)] += multiway.At(index...)
+		if index.Inc(multiway.Dims) {
+			break
+		}
+	}
+	return m
+}
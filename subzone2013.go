@@ -1,15 +1,15 @@
 package synth
 
 import (
+	"encoding/csv"
 	"io"
 	"log"
 	"os"
 	"runtime"
-
-	"bitbucket.org/SeheonKim/albatros4/tools"
 )
 
-// Subzone defines the data needed for a subzone
+// Subzone defines the data needed for a subzone. The csv tags are the columns of the
+// 2013 subzones file, which is described by DefaultControlSchema.
 type Subzone struct {
 	Id                     int `csv:"subzone"`
 	Prov                   int
@@ -40,59 +40,116 @@ type Subzone struct {
 	WorkHouseholdRowTotal3 float64 `csv:"vr ft"`
 	WorkHouseholdRowTotal4 float64 `csv:"ma ind"`
 	WorkHouseholdRowTotal5 float64 `csv:"ma liv"`
+
+	// breaks are the totals of coarser census breaks that still have to be split over
+	// their categories, see splitBreaks
+	breaks []breakTotal
+}
+
+// breakTotal is the total of a ControlBreak of a subzone
+type breakTotal struct {
+	ControlBreak
+	total float64
 }
 
+// SpatialSegment returns the spatial segment of the subzone in the default segmentation
 func (s *Subzone) SpatialSegment() int {
-	return C_spatial_segment(s.Sted, s.Prov)
+	return DefaultSpatialSegmentation.SubzoneSegment(s)
+}
+
+// totalFields returns the fields of the categories of a control table, see controlTableSizes
+func (s *Subzone) totalFields(table string) []*float64 {
+	switch table {
+	case "AgeHouseholdCol":
+		return []*float64{&s.AgeHouseholdColTotal1, &s.AgeHouseholdColTotal2, &s.AgeHouseholdColTotal3, &s.AgeHouseholdColTotal4, &s.AgeHouseholdColTotal5, &s.AgeHouseholdColTotal6, &s.AgeHouseholdColTotal7}
+	case "AgeHouseholdRow":
+		return []*float64{&s.AgeHouseholdRowTotal1, &s.AgeHouseholdRowTotal2, &s.AgeHouseholdRowTotal3, &s.AgeHouseholdRowTotal4, &s.AgeHouseholdRowTotal5, &s.AgeHouseholdRowTotal6, &s.AgeHouseholdRowTotal7}
+	case "WorkHouseholdCol":
+		return []*float64{&s.WorkHouseholdColTotal1, &s.WorkHouseholdColTotal2, &s.WorkHouseholdColTotal3, &s.WorkHouseholdColTotal4, &s.WorkHouseholdColTotal5}
+	case "WorkHouseholdRow":
+		return []*float64{&s.WorkHouseholdRowTotal1, &s.WorkHouseholdRowTotal2, &s.WorkHouseholdRowTotal3, &s.WorkHouseholdRowTotal4, &s.WorkHouseholdRowTotal5}
+	}
+	log.Panicln("Unknown control table", table)
+	return nil
+}
+
+// Totals returns the control totals of the given control table
+func (s *Subzone) Totals(table string) []float64 {
+	fields := s.totalFields(table)
+	totals := make([]float64, len(fields))
+	for i, f := range fields {
+		totals[i] = *f
+	}
+	return totals
 }
 
 func (s *Subzone) AgeHouseholdColTotals() []float64 {
-	return []float64{s.AgeHouseholdColTotal1, s.AgeHouseholdColTotal2, s.AgeHouseholdColTotal3, s.AgeHouseholdColTotal4, s.AgeHouseholdColTotal5, s.AgeHouseholdColTotal6, s.AgeHouseholdColTotal7}
+	return s.Totals("AgeHouseholdCol")
 }
 
 func (s *Subzone) AgeHouseholdRowTotals() []float64 {
-	return []float64{s.AgeHouseholdRowTotal1, s.AgeHouseholdRowTotal2, s.AgeHouseholdRowTotal3, s.AgeHouseholdRowTotal4, s.AgeHouseholdRowTotal5, s.AgeHouseholdRowTotal6, s.AgeHouseholdRowTotal7}
+	return s.Totals("AgeHouseholdRow")
 }
 
 func (s *Subzone) WorkHouseholdColTotals() []float64 {
-	return []float64{s.WorkHouseholdColTotal1, s.WorkHouseholdColTotal2, s.WorkHouseholdColTotal3, s.WorkHouseholdColTotal4, s.WorkHouseholdColTotal5}
+	return s.Totals("WorkHouseholdCol")
 }
 
 func (s *Subzone) WorkHouseholdRowTotals() []float64 {
-	return []float64{s.WorkHouseholdRowTotal1, s.WorkHouseholdRowTotal2, s.WorkHouseholdRowTotal3, s.WorkHouseholdRowTotal4, s.WorkHouseholdRowTotal5}
+	return s.Totals("WorkHouseholdRow")
+}
+
+// splitBreaks adds the totals of the coarser census breaks of a control table to the
+// categories they cover, in proportion to the seed counts of those categories, or
+// evenly when the seed has none. After that the breaks of the table are done.
+func (s *Subzone) splitBreaks(table string, seed []float64) {
+	fields := s.totalFields(table)
+	remaining := s.breaks[:0]
+	for _, b := range s.breaks {
+		if b.Table != table {
+			remaining = append(remaining, b)
+			continue
+		}
+
+		covered := seed[b.First : b.Last+1]
+		n := sum(covered)
+		for i := range covered {
+			share := 1 / float64(len(covered))
+			if n > 0 {
+				share = covered[i] / n
+			}
+			*fields[b.First+i] += b.total * share
+		}
+	}
+	s.breaks = remaining
 }
 
-// N_spatial_segment defines the number of spatial segment levels
-var N_spatial_segment = 5
+// N_spatial_segment defines the number of spatial segment levels of the default segmentation
+var N_spatial_segment = DefaultSpatialSegmentation.N
 
-// C_spatial_segment classifies a spatial segment using the given sted and prov values. // from Albatross book 2.0 at p.38
-// Sted in MON 2004: 1 - 5 (changed to) -> // hh.Urb: 0 - 4
+// C_spatial_segment classifies a spatial segment using the given sted and prov values
+// in the default segmentation.
 func C_spatial_segment(sted, prov int) int {
-	switch {
-	case sted == 0:
-		return 0
-	case sted == 1:
-		return 1
-	case sted == 2:
-		return 2
-	case prov == 4 || prov == 9 || prov == 11 || prov == 12:
-		return 4
-	default:
-		return 3
-	}
+	return DefaultSpatialSegmentation.Segment(sted, prov)
 }
 
-func readSubzones(filename string, c chan *Subzone) {
+func readSubzones(filename string, schema *ControlSchema, c chan *Subzone) {
 	file, err := os.Open(filename)
 	if err != nil {
 		log.Panicln(err)
 	}
 	defer file.Close()
 
-	csv := tools.NewCsvReader(file, '\t')
+	r := csv.NewReader(file)
+	r.Comma = '\t'
+	header, err := r.Read()
+	if err != nil {
+		log.Panicln("Error reading subzones header:", err)
+	}
+	p := schema.positions(header)
+
 	for {
-		ss := new(Subzone)
-		err := csv.Read(ss)
+		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
@@ -100,17 +157,22 @@ func readSubzones(filename string, c chan *Subzone) {
 			log.Panicln(err)
 		}
 
-		c <- ss
+		c <- p.subzone(record)
 	}
 }
 
 // ReadSubzones returns a channel on witch the subzones will be returned.
 // You must read all members until the channel is closed.
 func ReadSubzones(filename string) <-chan *Subzone {
+	return ReadSubzonesWithSchema(filename, DefaultControlSchema)
+}
+
+// ReadSubzonesWithSchema is like ReadSubzones but reads the columns described by the given schema.
+func ReadSubzonesWithSchema(filename string, schema *ControlSchema) <-chan *Subzone {
 	c := make(chan *Subzone, runtime.NumCPU()*5)
 	go func() {
 		defer close(c)
-		readSubzones(filename, c)
+		readSubzones(filename, schema, c)
 	}()
 	return c
 }
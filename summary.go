@@ -0,0 +1,252 @@
+package synth
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// summaryVariable is a variable of a population summary with the categories of a household.
+// Household variables have one category per household, head variables one per head.
+type summaryVariable struct {
+	name       string
+	categories func(*model.Household) []int
+}
+
+// headCategories returns the categories of the heads of a household
+func headCategories(category func(*model.Person) int) func(*model.Household) []int {
+	return func(hh *model.Household) (categories []int) {
+		for _, mem := range hh.Member {
+			if mem.Head {
+				categories = append(categories, category(mem))
+			}
+		}
+		return
+	}
+}
+
+// summaryVariables are the variables of a population summary
+var summaryVariables = []summaryVariable{
+	{"Comp", func(hh *model.Household) []int { return []int{int(hh.Comp)} }},
+	{"Child", func(hh *model.Household) []int { return []int{int(hh.Child)} }},
+	{"SEC", func(hh *model.Household) []int { return []int{int(hh.Sec)} }},
+	{"Ncar", func(hh *model.Household) []int { return []int{int(hh.NumCars)} }},
+	{"Urb", func(hh *model.Household) []int { return []int{int(hh.Urb)} }},
+	{"Day", func(hh *model.Household) []int { return []int{int(hh.Day)} }},
+	{"FEV", func(hh *model.Household) []int { return []int{int(flag(hh.FEV))} }},
+	{"PHEV", func(hh *model.Household) []int { return []int{int(flag(hh.PHEV))} }},
+	{"HeadAge", headCategories(func(p *model.Person) int { return int(p.Age) })},
+	{"HeadWork", headCategories(func(p *model.Person) int { return int(p.Work) })},
+	{"HeadGender", headCategories(func(p *model.Person) int { return int(p.Gender) })},
+}
+
+// GroupSummary holds the distributions of the summary variables of a group of households
+type GroupSummary struct {
+	Households int
+	Counts     map[string]map[int]int // Count per category per variable
+}
+
+func newGroupSummary() *GroupSummary {
+	g := &GroupSummary{Counts: make(map[string]map[int]int)}
+	for _, v := range summaryVariables {
+		g.Counts[v.name] = make(map[int]int)
+	}
+	return g
+}
+
+// add counts the categories of a household
+func (g *GroupSummary) add(hh *model.Household) {
+	g.Households++
+	for _, v := range summaryVariables {
+		for _, c := range v.categories(hh) {
+			g.Counts[v.name][c]++
+		}
+	}
+}
+
+// share returns the share of a category of a variable in percent
+func (g *GroupSummary) share(variable string, category int) float64 {
+	total := 0
+	for _, n := range g.Counts[variable] {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(g.Counts[variable][category]) / float64(total)
+}
+
+// PopulationSummary holds the distributions of the summary variables of a population,
+// in total and cross tabulated by a group such as the province or spatial segment.
+type PopulationSummary struct {
+	GroupName string
+	Total     *GroupSummary
+	Groups    map[int]*GroupSummary
+}
+
+// SummarizePopulation summarises the households. When group is not nil, the households
+// are also summarised per group, using a ShardKey such as ShardByProvince or ShardBySpatialSegment.
+func SummarizePopulation(hhs <-chan *model.Household, groupName string, group ShardKey) *PopulationSummary {
+	s := &PopulationSummary{
+		GroupName: groupName,
+		Total:     newGroupSummary(),
+		Groups:    make(map[int]*GroupSummary),
+	}
+	for hh := range hhs {
+		s.Total.add(hh)
+		if group == nil {
+			continue
+		}
+
+		key := group(hh)
+		if key < 0 {
+			key = -1
+		}
+		if s.Groups[key] == nil {
+			s.Groups[key] = newGroupSummary()
+		}
+		s.Groups[key].add(hh)
+	}
+	return s
+}
+
+// groupKeys returns the sorted keys of the groups of all summaries
+func groupKeys(summaries ...*PopulationSummary) (keys []int) {
+	seen := make(map[int]bool)
+	for _, s := range summaries {
+		for key := range s.Groups {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Ints(keys)
+	return
+}
+
+// variableCategories returns the sorted categories of a variable in the totals of all summaries
+func variableCategories(variable string, summaries ...*PopulationSummary) (categories []int) {
+	seen := make(map[int]bool)
+	for _, s := range summaries {
+		for c := range s.Total.Counts[variable] {
+			if !seen[c] {
+				seen[c] = true
+				categories = append(categories, c)
+			}
+		}
+	}
+	sort.Ints(categories)
+	return
+}
+
+// groupLabel returns the column label of a group
+func (s *PopulationSummary) groupLabel(key int) string {
+	if key < 0 {
+		return s.GroupName + " unknown"
+	}
+	return fmt.Sprint(s.GroupName, " ", key)
+}
+
+// writeTable writes the rows as a tab separated table
+func writeTable(out io.Writer, header []string, rows [][]string) error {
+	done := false
+	_, err := writeRecords(out, '\t', header, func() ([][]string, bool) {
+		if done {
+			return nil, false
+		}
+		done = true
+		return rows, true
+	})
+	return err
+}
+
+// Write writes the summary as a tab separated table with the count and share in percent
+// of every category of every variable, in total and for every group.
+func (s *PopulationSummary) Write(out io.Writer) error {
+	keys := groupKeys(s)
+	groups := []*GroupSummary{s.Total}
+	header := []string{"Variable", "Category", "Total", "Total %"}
+	for _, key := range keys {
+		groups = append(groups, s.Groups[key])
+		header = append(header, s.groupLabel(key), s.groupLabel(key)+" %")
+	}
+
+	rows := [][]string{
+		append([]string{"Households", ""}, householdCounts(groups)...),
+	}
+	for _, v := range summaryVariables {
+		for _, c := range variableCategories(v.name, s) {
+			row := []string{v.name, d(c)}
+			for _, g := range groups {
+				row = append(row, d(g.Counts[v.name][c]), fmt.Sprintf("%.2f", g.share(v.name, c)))
+			}
+			rows = append(rows, row)
+		}
+	}
+	return writeTable(out, header, rows)
+}
+
+// householdCounts returns the number of households of each group with an empty share column
+func householdCounts(groups []*GroupSummary) (counts []string) {
+	for _, g := range groups {
+		counts = append(counts, d(g.Households), "")
+	}
+	return
+}
+
+// WriteSummaryDiff writes what changed between two population summaries, such as the
+// populations of two scenarios, as a tab separated table. For every category of every
+// variable, in total and for every group, it gives the counts of both populations,
+// their difference and the difference of the shares in percent points.
+func WriteSummaryDiff(out io.Writer, a, b *PopulationSummary) error {
+	header := []string{"Group", "Variable", "Category", "A", "B", "B-A", "A %", "B %", "B-A %"}
+
+	var rows [][]string
+	diff := func(label string, ga, gb *GroupSummary) {
+		if ga == nil {
+			ga = newGroupSummary()
+		}
+		if gb == nil {
+			gb = newGroupSummary()
+		}
+		rows = append(rows, []string{label, "Households", "", d(ga.Households), d(gb.Households), d(gb.Households - ga.Households), "", "", ""})
+		for _, v := range summaryVariables {
+			for _, c := range variableCategories(v.name, a, b) {
+				na, nb := ga.Counts[v.name][c], gb.Counts[v.name][c]
+				sa, sb := ga.share(v.name, c), gb.share(v.name, c)
+				rows = append(rows, []string{
+					label, v.name, d(c),
+					d(na), d(nb), d(nb - na),
+					fmt.Sprintf("%.2f", sa), fmt.Sprintf("%.2f", sb), fmt.Sprintf("%.2f", sb-sa),
+				})
+			}
+		}
+	}
+
+	diff("Total", a.Total, b.Total)
+	for _, key := range groupKeys(a, b) {
+		diff(a.groupLabel(key), a.Groups[key], b.Groups[key])
+	}
+	return writeTable(out, header, rows)
+}
+
+// WriteSynthFilesSummary summarises the households of synth files, such as the shards
+// written by WriteShardedFiles, and writes the summary to out. The files are read by
+// ReadSynthFiles. When group is not nil the households are also summarised per group,
+// for example per province with ShardByProvince or per spatial segment with
+// ShardBySpatialSegment. It is a library function, this package has no command for it.
+func WriteSynthFilesSummary(out io.Writer, groupName string, group ShardKey, filenames ...string) error {
+	return SummarizePopulation(ReadSynthFiles(filenames...), groupName, group).Write(out)
+}
+
+// WriteSynthFilesDiff writes the WriteSummaryDiff of the populations in the synth files
+// a and b, such as the populations of two scenarios, to out. Like WriteSynthFilesSummary
+// it is a library function.
+func WriteSynthFilesDiff(out io.Writer, groupName string, group ShardKey, a, b []string) error {
+	sa := SummarizePopulation(ReadSynthFiles(a...), groupName, group)
+	sb := SummarizePopulation(ReadSynthFiles(b...), groupName, group)
+	return WriteSummaryDiff(out, sa, sb)
+}
@@ -0,0 +1,73 @@
+package synth
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// writeSynthFile writes households with the given urbanisation, province and composition
+func writeSynthFile(t *testing.T, name string, attrs ...[3]int) string {
+	t.Helper()
+	hhs := randomHouseholds(3, len(attrs))
+	for i, a := range attrs {
+		hhs[i].Urb, hhs[i].Prov, hhs[i].Comp = model.Urb(a[0]), a[1], model.Comp(a[2])
+	}
+	filename := filepath.Join(t.TempDir(), name)
+	if _, err := WriteCsvFile(filename, send(hhs)); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestWriteSynthFilesDiff(t *testing.T) {
+	a := writeSynthFile(t, "a.csv", [3]int{0, 1, 1}, [3]int{3, 4, 1})
+	b := writeSynthFile(t, "b.csv", [3]int{0, 1, 1}, [3]int{0, 2, 2}, [3]int{3, 4, 1})
+
+	var out bytes.Buffer
+	if err := WriteSynthFilesDiff(&out, "Segment", ShardBySpatialSegment(DefaultSpatialSegmentation), []string{a}, []string{b}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := csv.NewReader(&out)
+	r.Comma = '\t'
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(records[0], ","); got != "Group,Variable,Category,A,B,B-A,A %,B %,B-A %" {
+		t.Errorf("header is %s", got)
+	}
+	rows := make(map[string]string)
+	for _, record := range records[1:] {
+		rows[strings.Join(record[:3], ",")] = strings.Join(record[3:], ",")
+	}
+
+	tests := []struct {
+		row  string
+		want string
+	}{
+		{"Total,Households,", "2,3,1,,,"},
+		{"Total,Comp,1", "2,2,0,100.00,66.67,-33.33"},
+		{"Total,Comp,2", "0,1,1,0.00,33.33,33.33"},
+		{"Total,Urb,0", "1,2,1,50.00,66.67,16.67"},
+		{"Segment 0,Households,", "1,2,1,,,"},
+		{"Segment 0,Comp,2", "0,1,1,0.00,50.00,50.00"},
+		{"Segment 4,Households,", "1,1,0,,,"},
+		{"Segment 4,Comp,1", "1,1,0,100.00,100.00,0.00"},
+	}
+	for _, test := range tests {
+		if got, exists := rows[test.row]; !exists {
+			t.Errorf("row %s is missing", test.row)
+		} else if got != test.want {
+			t.Errorf("row %s is %s, want %s", test.row, got, test.want)
+		}
+	}
+	if _, exists := rows["Segment 3,Households,"]; exists {
+		t.Errorf("segment 3 has no households, but has a row")
+	}
+}
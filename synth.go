@@ -3,6 +3,7 @@
 package synth
 
 import (
+	"fmt"
 	"log"
 	"math"
 	"math/rand"
@@ -18,11 +19,115 @@ import (
 // SynthesizePopulationParams contains the parameters needed by the SynthesizePopulation function
 type SynthesizePopulationParams struct {
 	IndependentVars  []string
-	MonDataFilename  string
+	MonDataFilename  string // Used when no SeedSource is given
 	SubZonesFilename string
-	ZipCodesFilename string
-	LocsNLFilename   string //        *model.LocsNL
-	IpfParams        tools.IpfParams
+	// ControlSchemaFilename is an optional file describing the columns of the subzones
+	// file (see ReadControlSchema). By default the DefaultControlSchema is used.
+	ControlSchemaFilename string
+	ZipCodesFilename      string
+	LocsNLFilename        string //        *model.LocsNL
+	IpfParams             tools.IpfParams
+
+	// DayDistribution gives the relative frequency of each of the 7 days of
+	// the week. When Day is not one of the IndependentVars, the day of every
+	// household is drawn from this distribution. When it is empty, Day is left 0.
+	DayDistribution []float64
+
+	// PersonControlsFilename is an optional file with person level control totals
+	// per subzone (see PersonControls). When given, the household and person level
+	// marginals of every subzone are fitted jointly. The Drivers totals are only
+	// used when Drivers is one of the IndependentVars.
+	PersonControlsFilename string
+
+	// Method selects how households are created from the fitted tables. The
+	// default MethodIpf creates households from the table categories.
+	Method SynthesisMethod
+
+	// Integerisation selects how the fitted tables are rounded to whole households,
+	// by default with IntegeriseInvariant. The random methods are seeded per
+	// subzone from IntegerisationSeed, so runs are reproducible.
+	Integerisation     IntegerisationMethod
+	IntegerisationSeed int64
+
+	// IntegerisationReportFilename is an optional csv file to which the marginal
+	// error of the integerisation is written per subzone.
+	IntegerisationReportFilename string
+
+	// DiagnosticsFilename is an optional csv file to which the fit of the synthesized
+	// households to the control totals is written per subzone.
+	DiagnosticsFilename string
+
+	// CoverageFilename is an optional csv file listing every skipped subzone with the reason and
+	// the number of households and persons that were not synthesized because of it.
+	CoverageFilename string
+
+	// MinCoverage is the minimal fraction of the households of all subzones that must be
+	// synthesized. When the coverage is lower the run returns an error, see SynthesisSummary.
+	// Zero disables the check.
+	MinCoverage float64
+
+	// SeedSource supplies the seed households. By default they are read from MonDataFilename.
+	SeedSource SeedSource
+
+	// SpatialSegmentsFilename is an optional file with the rules that classify subzones and
+	// seed households into spatial segments (see SpatialSegmentRule). By default the
+	// DefaultSpatialSegmentation is used. Subzones in a segment of a rule for specific
+	// subzones are fitted with the seed households of their SeedSegment.
+	SpatialSegmentsFilename string
+
+	// SynthesizeMembers adds the members other than the heads, such as children, to the
+	// households of MethodIpf. They are copied from a seed household with the same child
+	// category and maximum age of the heads, so Child must be one of the IndependentVars.
+	// The drivers are assigned to the heads first and then to the drawn members. With
+	// MethodSample all members are always kept.
+	SynthesizeMembers bool
+
+	// SeedCounting selects per seed table (AgeHousehold, WorkHousehold and AgeWorkHousehold,
+	// which also applies to the multiway table) whether households or persons are counted.
+	// Tables that are not given count households. Before, every head was counted, so a
+	// couple counted twice; give CountPersons to reproduce the counts of earlier runs.
+	SeedCounting map[string]CountingMode
+
+	// SeedSmoothing lets the count tables of sparse spatial segments borrow counts from
+	// all segments, such that combinations that exist nationally can still be fitted.
+	SeedSmoothing SeedSmoothing
+}
+
+// SynthesisMethod defines how the households of a subzone are created from its fitted multiway table
+type SynthesisMethod string
+
+const (
+	// MethodIpf creates for every count in a table cell a household with the categories of that cell
+	MethodIpf SynthesisMethod = "ipf"
+	// MethodSample draws for every count in a table cell a seed household that falls in that cell
+	// and copies it with all its attributes and members.
+	MethodSample SynthesisMethod = "sample"
+)
+
+// CountingMode defines how the members of seed households are counted in a seed table
+type CountingMode string
+
+const (
+	// CountHouseholds counts the heads of a household once, with the household weight.
+	// Members living in are still counted each, with their own weight.
+	CountHouseholds CountingMode = "household"
+	// CountPersons counts every member of a household with its person weight
+	CountPersons CountingMode = "person"
+)
+
+// seedTables are the seed tables for which a counting mode can be given
+var seedTables = []string{"AgeHousehold", "WorkHousehold", "AgeWorkHousehold"}
+
+// weight returns the weight a member is counted with in a table with the given mode.
+// It returns 0 when the member should not be counted.
+func (mode CountingMode) weight(m *MonMember) float64 {
+	if mode == CountPersons || m.Household == 2 {
+		return m.Weight
+	}
+	if m.FirstHead {
+		return m.HouseholdWeight
+	}
+	return 0
 }
 
 // countTable is used to count the different categories for each spatial zone
@@ -38,7 +143,10 @@ type countTable struct {
 // subzoneResult adds the fitted multiwaytable to a subzone
 type subzoneResult struct {
 	subzone             *Subzone
+	segment             int // The spatial segment of the seed households
 	fittedMultiwayTable *mat.Mat
+	integerisationError IntegerisationError
+	skipReason          string // Set when no table was created for the subzone
 }
 
 // max returns the maximum of two floats
@@ -73,25 +181,88 @@ func newCountTable(spatialSegment int, indepVars []string) *countTable {
 }
 
 // countMonData fills all countTables for all spatial segments by reading in the mon data.
-func countMonData(filename string, countTables []*countTable, indepVars []string) {
+// Members are counted with their expansion weight, per household or per person as given by counting.
+// Members of households that are not in any spatial segment are not counted.
+func countMonData(source SeedSource, countTables []*countTable, indepVars []string, segmentation *SpatialSegmentation, counting map[string]CountingMode) {
 	log.Println("Counting mon data")
 	start := time.Now()
-	c := ReadMonData(filename)
+
+	// All count tables of a kind have the same dimensions
+	ageStrides := matStrides(countTables[0].ageHouseholdTable.Dims)
+	workStrides := matStrides(countTables[0].workHouseholdTable.Dims)
+	ageWorkStrides := matStrides(countTables[0].ageWorkHouseholdTable.Dims)
+	multiwayStrides := matStrides(countTables[0].multiwayTable.Dims)
+
+	modes := make(map[string]CountingMode)
+	for _, table := range seedTables {
+		if modes[table] = counting[table]; modes[table] == "" {
+			modes[table] = CountHouseholds
+		}
+	}
+
+	c := ReadSeedData(source, segmentation)
+	unsegmented, members, households := 0, 0, 0
 	for r := range c {
+		if r.SpatialSegment == -1 {
+			unsegmented++
+			continue
+		}
+		members++
+		if r.FirstHead {
+			households++
+		}
+
 		t := countTables[r.SpatialSegment]
-		t.ageHouseholdTable.Inc(r.AgeHousehold.Index()...)
-		t.workHouseholdTable.Inc(r.WorkHousehold.Index()...)
+		t.ageHouseholdTable.Vals[offset(r.AgeHousehold.Index(), ageStrides)] += modes["AgeHousehold"].weight(r)
+		t.workHouseholdTable.Vals[offset(r.WorkHousehold.Index(), workStrides)] += modes["WorkHousehold"].weight(r)
 		if r.AgeWorkHousehold.U != -1 {
-			t.ageWorkHouseholdTable.Inc(r.AgeWorkHousehold.Index()...)
-			t.multiwayTable.Inc(r.Index(indepVars)...)
+			w := modes["AgeWorkHousehold"].weight(r)
+			t.ageWorkHouseholdTable.Vals[offset(r.AgeWorkHousehold.Index(), ageWorkStrides)] += w
+			t.multiwayTable.Vals[offset(r.Index(indepVars), multiwayStrides)] += w
 		}
 	}
+	if unsegmented > 0 {
+		log.Println("Skipped", unsegmented, "mon members without a spatial segment")
+	}
+	log.Println("Counted", members, "mon members in", households, "households")
 	log.Println("Done counting in", time.Since(start))
 }
 
+// splitSubzoneBreaks splits the totals of the coarser census breaks of the subzone over
+// their categories by the marginals of the seed tables.
+func splitSubzoneBreaks(subzone *Subzone, countTable *countTable) {
+	ageCols, ageRows := marginals(countTable.ageHouseholdTable)
+	workCols, workRows := marginals(countTable.workHouseholdTable)
+	subzone.splitBreaks("AgeHouseholdCol", ageCols)
+	subzone.splitBreaks("AgeHouseholdRow", ageRows)
+	subzone.splitBreaks("WorkHouseholdCol", workCols)
+	subzone.splitBreaks("WorkHouseholdRow", workRows)
+}
+
+// marginals returns the sums over the second and first dimension of a two dimensional table,
+// which are fitted to the column and row totals of a control table.
+func marginals(m *mat.Mat) (cols, rows []float64) {
+	cols = make([]float64, m.Dims[0])
+	rows = make([]float64, m.Dims[1])
+	for i := range cols {
+		for j := range rows {
+			v := m.At(i, j)
+			cols[i] += v
+			rows[j] += v
+		}
+	}
+	return
+}
+
 // Create a fitted multiway table for a subzone.
-// The countTable should match the countable for the same spatial segment as the supplied subzone
-func createFittedMultiwayTable(subzone *Subzone, countTable *countTable, ipfParams tools.IpfParams) *mat.Mat {
+// The countTable should match the countable for the same spatial segment as the supplied subzone.
+// When person controls are given the household and person level marginals are fitted jointly
+// before the table is rounded.
+// The table is integerised with the method from args and the error this introduced is returned as well.
+func createFittedMultiwayTable(subzone *Subzone, countTable *countTable, args SynthesizePopulationParams, personControls *PersonControls) (*mat.Mat, IntegerisationError) {
+	ipfParams := args.IpfParams
+	splitSubzoneBreaks(subzone, countTable)
+
 	ageHouseholdTable := countTable.ageHouseholdTable.Clone()
 	fagm, _, convergence1 := tools.Ipf(ageHouseholdTable, subzone.AgeHouseholdColTotals(), subzone.AgeHouseholdRowTotals(), ipfParams)
 	if convergence1 > ipfParams.ConvLevel {
@@ -159,15 +330,47 @@ func createFittedMultiwayTable(subzone *Subzone, countTable *countTable, ipfPara
 			}
 		}
 	}
-	fmwt.InvariantRound()
 
-	return fmwt
+	if constraints := personConstraints(personControls, args.IndependentVars); len(constraints) > 0 {
+		if convergence := fitHierarchical(fmwt, colTotals, rowTotals, constraints, ipfParams.ConvLevel); convergence > ipfParams.ConvLevel {
+			log.Printf("The hierarchical fit in subzone %d didn't converge, there is a %.1f%% difference", subzone.Id, convergence*100.0)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(args.IntegerisationSeed + int64(subzone.Id)))
+	integerisationError := integerise(subzone.Id, fmwt, args.Integerisation, rng)
+
+	return fmwt, integerisationError
+}
+
+// skipReason returns why no households can be synthesized for the subzone in the
+// given spatial segment and seed segment or an empty string if they can.
+func skipReason(subzone *Subzone, segment, seedSegment int) string {
+	switch {
+	case segment == -1:
+		return "no spatial segment"
+	case seedSegment == -1:
+		return "no seed segment"
+	case subzone.Bevolking == 0:
+		return "#population = 0"
+	case subzone.Huishoudens == 0:
+		return "#households = 0"
+	case subzone.Huishoudens > subzone.Bevolking:
+		return "#households > #population"
+	}
+	return ""
 }
 
 // createMultiwayTablePerSubzone reads the subzones data and creates a multiway table for each subzone and then
-// sends the result on the returned output channel.
-func createMultiwayTablePerSubzone(filename string, countTables []*countTable, ipfParams tools.IpfParams) <-chan *subzoneResult {
-	input := ReadSubzones(filename)
+// sends the result on the returned output channel. Skipped subzones are sent
+// with the reason they were skipped.
+func createMultiwayTablePerSubzone(args SynthesizePopulationParams, countTables []*countTable, segmentation *SpatialSegmentation, personControls map[int]*PersonControls) <-chan *subzoneResult {
+	schema := DefaultControlSchema
+	if args.ControlSchemaFilename != "" {
+		schema = ReadControlSchema(args.ControlSchemaFilename)
+	}
+
+	input := ReadSubzonesWithSchema(args.SubZonesFilename, schema)
 	outputa := make(chan *subzoneResult, 10)
 	wg := 0
 	for i := 0; i < runtime.NumCPU()-1; i++ {
@@ -175,16 +378,25 @@ func createMultiwayTablePerSubzone(filename string, countTables []*countTable, i
 		go func() {
 
 			for subzone := range input {
-				if subzone.Huishoudens > subzone.Bevolking || subzone.Bevolking == 0 || subzone.Huishoudens == 0 {
-					log.Printf("Skipping subzone %d because: #houshold > #population or #households = 0 or #population = 0", subzone.Id)
+				segment := segmentation.SeedSegment(subzone)
+				if reason := skipReason(subzone, segmentation.SubzoneSegment(subzone), segment); reason != "" {
+					log.Printf("Skipping subzone %d because: %s", subzone.Id, reason)
+					outputa <- &subzoneResult{subzone: subzone, skipReason: reason}
 					continue
 				} else {
 					log.Printf("Processing subzone %d", subzone.Id)
 				}
 
-				fmwt := createFittedMultiwayTable(subzone, countTables[subzone.SpatialSegment()], ipfParams)
+				var controls *PersonControls
+				if personControls != nil {
+					if controls = personControls[subzone.Id]; controls == nil {
+						log.Printf("No person controls for subzone %d, fitting household marginals only", subzone.Id)
+					}
+				}
+
+				fmwt, integerisationError := createFittedMultiwayTable(subzone, countTables[segment], args, controls)
 
-				outputa <- &subzoneResult{subzone, fmwt}
+				outputa <- &subzoneResult{subzone: subzone, segment: segment, fittedMultiwayTable: fmwt, integerisationError: integerisationError}
 			}
 			outputa <- nil
 		}()
@@ -210,45 +422,153 @@ func createMultiwayTablePerSubzone(filename string, countTables []*countTable, i
 	return outputb
 }
 
-func synthesizePopulationToHouseholds(args SynthesizePopulationParams, c chan *model.Household) {
+// synthesizedHousehold is a household with the subzone and spatial segment it is synthesized in
+type synthesizedHousehold struct {
+	hh      *model.Household
+	subzone int
+	segment int
+}
+
+// SynthesisSummary summarises a synthesis run. It is complete when the channel of
+// households is closed.
+type SynthesisSummary struct {
+	Coverage float64 // The fraction of the households of all subzones that was synthesized
+	Skipped  []SkippedSubzone
+
+	// Err is set when the coverage is below MinCoverage or a report could not be
+	// written. The households are synthesized anyway.
+	Err error
+}
+
+// fail sets the error of the summary, unless it already has one
+func (s *SynthesisSummary) fail(err error) {
+	if err != nil && s.Err == nil {
+		s.Err = err
+	}
+}
+
+func synthesizePopulationToHouseholds(args SynthesizePopulationParams, c chan<- synthesizedHousehold, summary *SynthesisSummary) {
 	defer close(c)
 
 	locsnl := model.ReadLocsNLFile(args.LocsNLFilename)
 	zipcode := ReadZipcode(args.ZipCodesFilename)
 
+	seedSource := args.SeedSource
+	if seedSource == nil {
+		seedSource = &MonSeedSource{Filename: args.MonDataFilename}
+	}
+
 	// Create count tables
-	countTables := make([]*countTable, N_spatial_segment)
+	segmentation := DefaultSpatialSegmentation
+	if args.SpatialSegmentsFilename != "" {
+		segmentation = ReadSpatialSegmentation(args.SpatialSegmentsFilename)
+	}
+
+	countTables := make([]*countTable, segmentation.N)
 	for i := range countTables {
 		countTables[i] = newCountTable(i, args.IndependentVars)
 	}
 
 	// Do the counting
-	countMonData(args.MonDataFilename, countTables, args.IndependentVars)
+	countMonData(seedSource, countTables, args.IndependentVars, segmentation, args.SeedCounting)
+	smoothCountTables(countTables, args.SeedSmoothing)
 
-	subzoneResults := createMultiwayTablePerSubzone(args.SubZonesFilename, countTables, args.IpfParams)
+	var personControls map[int]*PersonControls
+	if args.PersonControlsFilename != "" {
+		personControls = ReadPersonControls(args.PersonControlsFilename)
+	}
+
+	var seeds *seedPool
+	var members *memberModel
+	if args.Method == MethodSample {
+		seeds = readSeedPool(seedSource, args.IndependentVars, segmentation)
+	} else if args.SynthesizeMembers {
+		members = readMemberModel(seedSource)
+	}
+
+	subzoneResults := createMultiwayTablePerSubzone(args, countTables, segmentation, personControls)
 	zipcodePerSubzone := ReadZipcodesPerSubzone(args.ZipCodesFilename)
 
 	// Instead of writeOutput in SynthesizePopulation, the housedhold is constructing from here
 	// This loop is similar to func constructHousehold in readmon.go
 	hhid := 1
 
+	drawDay := len(args.DayDistribution) > 0 && !hasVar(args.IndependentVars, "Day")
+
+	coverage := new(coverageReport)
+	defer func() {
+		summary.fail(coverage.finish(args.CoverageFilename, args.MinCoverage))
+		summary.Coverage = coverage.coverage()
+		summary.Skipped = coverage.skipped
+	}()
+
+	var integerisationErrors []IntegerisationError
+	if args.IntegerisationReportFilename != "" {
+		defer func() {
+			if err := WriteIntegerisationReport(args.IntegerisationReportFilename, integerisationErrors); err != nil {
+				summary.fail(fmt.Errorf("writing integerisation report: %v", err))
+			}
+		}()
+	}
+
+	var fits *fitReport
+	if args.DiagnosticsFilename != "" {
+		fits = new(fitReport)
+		defer func() {
+			if err := fits.write(args.DiagnosticsFilename); err != nil {
+				summary.fail(fmt.Errorf("writing diagnostics: %v", err))
+			}
+		}()
+	}
+
 	// Go over all the results from the channel
 	for result := range subzoneResults {
 		var hh model.Household
 
+		coverage.add(result.subzone)
+		if result.skipReason != "" {
+			coverage.skip(result.subzone, result.skipReason)
+			continue
+		}
+
+		integerisationErrors = append(integerisationErrors, result.integerisationError)
+		fit := fits.newSubzone(result.subzone)
+
+		total := int(math.Round(sum(result.fittedMultiwayTable.Vals)))
 		zipcodeSubzone := zipcodePerSubzone[result.subzone.Id]
+		if zipcodeSubzone == nil || len(zipcodeSubzone.ZipCodeCounts) == 0 {
+			if total == 0 {
+				continue // Nothing to place, like a subzone without households
+			}
+			log.Printf("Skipping %d subzone because it does not have any zipcode in the zipcode file", result.subzone.Id)
+			coverage.skip(result.subzone, "no zipcodes")
+			continue
+		}
 
-		if zipcodeSubzone == nil {
-			log.Printf("Skipping %d subzone because it does not exist in zipcode file", result.subzone.Id)
+		zipcodeSubzone.SetTotal(total)
+		origin := synthesizedHousehold{subzone: result.subzone.Id, segment: segmentation.SubzoneSegment(result.subzone)}
+
+		if args.Method == MethodSample {
+			var missing int
+			hhid, missing = sampleSubzone(result, seeds, hhid, func(hh *model.Household) {
+				if drawDay {
+					hh.Day = model.Day(drawCategory(args.DayDistribution))
+				}
+				assignHome(hh, zipcodeSubzone, locsnl, zipcode)
+				fit.add(hh)
+				origin.hh = hh
+				c <- origin
+			})
+			coverage.shortfall(result.subzone, missing)
 			continue
 		}
 
-		zipcodeSubzone.SetTotal(int(sum(result.fittedMultiwayTable.Vals)))
 		hh.Prov = result.subzone.Prov
 		hh.Urb = model.Urb(result.subzone.Sted - 1)
 
 		index := make(mat.Index, len(result.fittedMultiwayTable.Dims))
 		x, y := -0xDEADBEEF, -0xDEADBEEF
+		heads := 0
 		for {
 			if index[0] != x {
 				x = index[0]
@@ -281,6 +601,7 @@ func synthesizePopulationToHouseholds(args SynthesizePopulationParams, c chan *m
 					hh.Member[1].Gender = model.Gender(gender2)
 					hh.Member[1].Work = model.Work(work2)
 				}
+				heads = len(hh.Member)
 			}
 			if index[1] != y {
 				y = index[1]
@@ -293,6 +614,9 @@ func synthesizePopulationToHouseholds(args SynthesizePopulationParams, c chan *m
 				}
 			}
 
+			hh.Member = hh.Member[:heads] // Remove other members from previous iteration
+			drivers := -1
+
 			// Associate indep var name with value
 			for i, name := range args.IndependentVars {
 				switch name {
@@ -302,46 +626,33 @@ func synthesizePopulationToHouseholds(args SynthesizePopulationParams, c chan *m
 					hh.NumCars = int8(min(int(index[i+2]), 2))
 				case "Sec":
 					hh.Sec = model.Sec(index[i+2])
+				case "Day":
+					hh.Day = model.Day(index[i+2])
 				case "Drivers":
-					num := index[i+2]
-					for j := range hh.Member {
-						hh.Member[j].IsDriver = j < num
-					}
+					drivers = index[i+2]
+				default:
+					log.Panicln("Independent var", name, "is not supported by the synthesis")
 				}
 			}
-			if hh.Member[0].IsDriver {
-				hh.Driver = 1
-			}
 
 			count := int(result.fittedMultiwayTable.At(index...))
 
 			for i := 0; i < count; i++ {
 				hh.ID = hhid
-
-				if zc, err := strconv.Atoi(zipcodeSubzone.GetRandomZipcode()); err != nil {
-					log.Panicln(err)
-				} else {
-					hh.Home = model.Location(zc)
-					if locsnl.Ppc[hh.Home] == nil {
-						// hh.WoGem = 999999
-						log.Panicln("Home Ppc (", hh.Home, ") is not found in locsnl file! Compare zipcode file with locsnl file!")
-					} else {
-						hh.WoGem = locsnl.Ppc[hh.Home].Gem
-					}
+				if drawDay {
+					hh.Day = model.Day(drawCategory(args.DayDistribution))
 				}
-
-				// Distribute FEV/PHEV by postcodes (Location-based vars...)
-				if Binomial(1, float64(zipcode.Ppc[hh.Home].Fev)/float64(zipcode.Ppc[hh.Home].HH)) == 1 {
-					hh.FEV = true
-				} else {
-					hh.FEV = false
+				if members != nil {
+					hh.Member = append(hh.Member[:heads], members.draw(hh.Child, hh.MaxAge, heads+1)...)
 				}
-				if Binomial(1, float64(zipcode.Ppc[hh.Home].Phev)/float64(zipcode.Ppc[hh.Home].HH)) == 1 {
-					hh.PHEV = true
-				} else {
-					hh.PHEV = false
+				if drivers >= 0 {
+					assignDrivers(&hh, drivers)
 				}
-				c <- hh.Clone()
+
+				assignHome(&hh, zipcodeSubzone, locsnl, zipcode)
+				fit.add(&hh)
+				origin.hh = hh.Clone()
+				c <- origin
 				hhid++
 			}
 			if index.Inc(result.fittedMultiwayTable.Dims) {
@@ -351,6 +662,79 @@ func synthesizePopulationToHouseholds(args SynthesizePopulationParams, c chan *m
 	}
 }
 
+// assignDrivers makes the first num members of the household drivers, the heads
+// before the other members, as the Drivers category counts the drivers among all
+// members. The last category (3 or more) assigns 3 drivers.
+func assignDrivers(hh *model.Household, num int) {
+	for j, mem := range hh.Member {
+		mem.IsDriver = j < num
+	}
+	hh.Driver = 0
+	if hh.Member[0].IsDriver {
+		hh.Driver = 1
+	}
+}
+
+// assignHome draws a home zipcode for the household from the zipcodes of its subzone
+// and distributes the electric vehicles by the shares of that zipcode. Subzones without
+// zipcodes are skipped before, so the generator always has a zipcode.
+func assignHome(hh *model.Household, zipcodeSubzone *ZipCodeGenerator, locsnl *model.LocsNL, zipcode *ZipCode) {
+	ppc := zipcodeSubzone.GetRandomZipcode()
+	if zc, err := strconv.Atoi(ppc); err != nil {
+		log.Panicf("Invalid zipcode %q for subzone %d: %v", ppc, zipcodeSubzone.Subzone, err)
+	} else {
+		hh.Home = model.Location(zc)
+		if locsnl.Ppc[hh.Home] == nil {
+			// hh.WoGem = 999999
+			log.Panicln("Home Ppc (", hh.Home, ") is not found in locsnl file! Compare zipcode file with locsnl file!")
+		} else {
+			hh.WoGem = locsnl.Ppc[hh.Home].Gem
+		}
+	}
+
+	// Distribute FEV/PHEV by postcodes (Location-based vars...)
+	info := zipcode.Ppc[hh.Home]
+	if info == nil || info.HH <= 0 {
+		hh.FEV = false
+		hh.PHEV = false
+		return
+	}
+	if Binomial(1, float64(info.Fev)/float64(info.HH)) == 1 {
+		hh.FEV = true
+	} else {
+		hh.FEV = false
+	}
+	if Binomial(1, float64(info.Phev)/float64(info.HH)) == 1 {
+		hh.PHEV = true
+	} else {
+		hh.PHEV = false
+	}
+}
+
+// hasVar reports whether name is one of the given independent variables
+func hasVar(vars []string, name string) bool {
+	for _, v := range vars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// drawCategory draws a random category with a probability proportional to the given weights
+func drawCategory(weights []float64) int {
+	r := rand.Float64() * sum(weights)
+
+	v := 0.0
+	for i, w := range weights[:len(weights)-1] {
+		v += w
+		if r < v {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
 func Binomial(n float64, p float64) int {
 	var s float64
 	var d float64
@@ -385,15 +769,72 @@ func Binomial(n float64, p float64) int {
 	return x
 }
 
+// SynthesizePopulationToHouseholds synthesizes the population and returns its households.
+// An error of the run, such as a coverage below MinCoverage, is only logged, use
+// SynthesizePopulationWithSummary to handle it.
 func SynthesizePopulationToHouseholds(args SynthesizePopulationParams) <-chan *model.Household {
-	// Test correct names for independent variables
-	for _, name := range []string{"Child", "Sec", "NumCars", "Drivers"} {
-		if _, exists := IndepVarLevels[name]; !exists {
-			log.Fatalf("Independent var with name %s is not defined", name)
+	hhs, summary := SynthesizePopulationWithSummary(args)
+
+	c := make(chan *model.Household)
+	go func() {
+		defer close(c)
+		for hh := range hhs {
+			c <- hh
+		}
+		if summary.Err != nil {
+			log.Println("Synthesis failed:", summary.Err)
 		}
+	}()
+	return c
+}
+
+// SynthesizePopulationWithSummary synthesizes the population like
+// SynthesizePopulationToHouseholds. The summary, with the error of the run, is complete
+// when the channel of households is closed.
+func SynthesizePopulationWithSummary(args SynthesizePopulationParams) (<-chan *model.Household, *SynthesisSummary) {
+	if err := args.Validate(); err != nil {
+		log.Fatalln(err)
 	}
 
+	summary := new(SynthesisSummary)
+	synthesized := make(chan synthesizedHousehold)
+	go synthesizePopulationToHouseholds(args, synthesized, summary)
+
 	c := make(chan *model.Household)
-	go synthesizePopulationToHouseholds(args, c)
-	return c
+	go func() {
+		defer close(c)
+		for s := range synthesized {
+			c <- s.hh
+		}
+	}()
+	return c, summary
+}
+
+// SynthesizePopulationToShards synthesizes the population like SynthesizePopulationToHouseholds
+// and writes every household to the shard file of the subzone and spatial segment it is
+// synthesized in, see OriginKey and WriteShardedFiles. It returns the number of households
+// written per shard and the first error of any shard or else the error of the run, see
+// SynthesisSummary.
+func SynthesizePopulationToShards(args SynthesizePopulationParams, filename string, key OriginKey) (map[int]int, error) {
+	if err := args.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	summary := new(SynthesisSummary)
+	synthesized := make(chan synthesizedHousehold)
+	go synthesizePopulationToHouseholds(args, synthesized, summary)
+
+	c := make(chan shardedHousehold)
+	go func() {
+		defer close(c)
+		for s := range synthesized {
+			c <- shardedHousehold{s.hh, key(s.subzone, s.segment)}
+		}
+	}()
+
+	rows, err := writeShardedFiles(filename, c)
+	if err == nil {
+		err = summary.Err
+	}
+	return rows, err
 }
@@ -0,0 +1,172 @@
+package synth
+
+import (
+	"testing"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+)
+
+// sliceSeedSource is a SeedSource of households in memory
+type sliceSeedSource []*SeedHousehold
+
+// Households implements SeedSource
+func (s sliceSeedSource) Households() <-chan *SeedHousehold {
+	c := make(chan *SeedHousehold, len(s))
+	for _, hh := range s {
+		c <- hh
+	}
+	close(c)
+	return c
+}
+
+// seedHousehold creates a seed household in segment 0 of the DefaultSpatialSegmentation
+func seedHousehold(id int, weight float64, personWeights []float64, members ...*model.Person) *SeedHousehold {
+	hh := model.NewHousehold()
+	hh.ID = id
+	hh.Prov = 1
+	for i, mem := range members {
+		mem.ID = i + 1
+		if mem.Head && mem.Age > hh.MaxAge {
+			hh.MaxAge = mem.Age
+		}
+	}
+	hh.Member = members
+	return &SeedHousehold{Household: hh, Weight: weight, PersonWeights: personWeights}
+}
+
+func person(head bool, gender model.Gender, age model.Age, work model.Work) *model.Person {
+	p := model.NewPerson()
+	p.Head = head
+	p.Gender = gender
+	p.Age = age
+	p.Work = work
+	return p
+}
+
+func TestCountMonData(t *testing.T) {
+	source := sliceSeedSource{
+		// A working single male of age class 2
+		seedHousehold(1, 2, nil, person(true, model.Male, 2, 1)),
+		// A couple of age class 1, counted once per household or once per partner
+		seedHousehold(2, 3, []float64{4, 5}, person(true, model.Male, 1, 2), person(true, model.Female, 1, 0)),
+		// A single female with a male living in, who is always counted himself
+		seedHousehold(3, 1, []float64{1, 7}, person(true, model.Female, 3, 0), person(false, model.Male, 0, 1)),
+	}
+
+	tests := []struct {
+		mode         CountingMode
+		total        float64 // Sum of the age household table
+		single       float64 // Cell of the single male
+		couple       float64 // Cell of the couple
+		coupleMulti  float64 // Cell of the couple in the age work household table
+		livingInCell float64 // Cell of the member living in
+	}{
+		{CountHouseholds, 2 + 3 + 1 + 7, 2, 3, 3, 7},
+		{CountPersons, 2 + 4 + 5 + 1 + 7, 2, 4 + 5, 4 + 5, 7},
+	}
+
+	for _, test := range tests {
+		countTables := make([]*countTable, DefaultSpatialSegmentation.N)
+		for i := range countTables {
+			countTables[i] = newCountTable(i, nil)
+		}
+		counting := map[string]CountingMode{"AgeHousehold": test.mode, "AgeWorkHousehold": test.mode}
+		countMonData(source, countTables, nil, DefaultSpatialSegmentation, counting)
+
+		age := countTables[0].ageHouseholdTable
+		if total := sum(age.Vals); total != test.total {
+			t.Errorf("%s: age household total is %v, want %v", test.mode, total, test.total)
+		}
+		if v := age.At(2, 5); v != test.single {
+			t.Errorf("%s: single male cell is %v, want %v", test.mode, v, test.single)
+		}
+		if v := age.At(1, 1); v != test.couple {
+			t.Errorf("%s: couple cell is %v, want %v", test.mode, v, test.couple)
+		}
+		if v := age.At(3, 6); v != test.livingInCell {
+			t.Errorf("%s: living in cell is %v, want %v", test.mode, v, test.livingInCell)
+		}
+		coupleIndex := UV{WorkHouseholdToIndex.Map(UV{2, 0}), AgeHouseholdToIndex.Map(UV{1, 1})}
+		if v := countTables[0].ageWorkHouseholdTable.At(coupleIndex.Index()...); v != test.coupleMulti {
+			t.Errorf("%s: couple age work household cell is %v, want %v", test.mode, v, test.coupleMulti)
+		}
+		for _, ct := range countTables[1:] {
+			if s := sum(ct.ageHouseholdTable.Vals); s != 0 {
+				t.Errorf("%s: segment %d has counts %v, want none", test.mode, ct.spatialSegment, s)
+			}
+		}
+	}
+}
+
+// monFixture is a small MON 2004 seed file with hand verified weighted totals:
+// a working single male (household weight 120.5, person weight 118), a couple with
+// a son living in (80.25; 75, 85 and 90) and a single female (40; 42) in segment 0,
+// a single male (55; 50) in segment 1 and a couple (30; 31 and 29) in segment 4.
+var monFixture = &CsvSeedSource{
+	Filename: "testdata/mon.txt",
+	Comma:    '\t',
+	Columns: map[string]string{
+		"Hhid": "hhid", "Prov": "prov", "Urb": "sted", "NumCars": "num_cars", "Sec": "sec",
+		"Child": "child", "Day": "day", "Head": "head", "Gender": "gender", "Age": "age",
+		"Work": "work", "Driver": "driver", "Weight": "hh_weight", "PersonWeight": "pers_weight",
+	},
+}
+
+func TestCountMonFixture(t *testing.T) {
+	if errs := monFixture.validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	couple := UV{WorkHouseholdToIndex.Map(UV{2, 0}), AgeHouseholdToIndex.Map(UV{1, 1})}
+	tests := []struct {
+		mode      CountingMode
+		segments  []float64 // Age household total per segment
+		work      float64   // Work household total of segment 0
+		multiway  float64   // Multiway total of segment 0, without the son living in
+		single    float64   // Age household cell of the single male in segment 0
+		couple    float64   // Multiway cell of the couple with 2 cars and 1 driver
+		livingIn  float64   // Work household cell of the son living in
+		singleFem float64   // Work household cell of the single female
+	}{
+		{CountHouseholds, []float64{120.5 + 80.25 + 90 + 40, 55, 0, 0, 30}, 330.75, 120.5 + 80.25 + 40, 120.5, 80.25, 90, 40},
+		{CountPersons, []float64{118 + 75 + 85 + 90 + 42, 50, 0, 0, 31 + 29}, 410, 118 + 75 + 85 + 42, 118, 75 + 85, 90, 42},
+	}
+
+	for _, test := range tests {
+		countTables := make([]*countTable, DefaultSpatialSegmentation.N)
+		for i := range countTables {
+			countTables[i] = newCountTable(i, []string{"NumCars", "Drivers"})
+		}
+		counting := map[string]CountingMode{"AgeHousehold": test.mode, "WorkHousehold": test.mode, "AgeWorkHousehold": test.mode}
+		countMonData(monFixture, countTables, []string{"NumCars", "Drivers"}, DefaultSpatialSegmentation, counting)
+
+		for segment, want := range test.segments {
+			if total := sum(countTables[segment].ageHouseholdTable.Vals); total != want {
+				t.Errorf("%s: age household total of segment %d is %v, want %v", test.mode, segment, total, want)
+			}
+		}
+
+		ct := countTables[0]
+		if total := sum(ct.workHouseholdTable.Vals); total != test.work {
+			t.Errorf("%s: work household total is %v, want %v", test.mode, total, test.work)
+		}
+		if total := sum(ct.multiwayTable.Vals); total != test.multiway {
+			t.Errorf("%s: multiway total is %v, want %v", test.mode, total, test.multiway)
+		}
+		if total := sum(ct.ageWorkHouseholdTable.Vals); total != test.multiway {
+			t.Errorf("%s: age work household total is %v, want %v", test.mode, total, test.multiway)
+		}
+		if v := ct.ageHouseholdTable.At(2, 5); v != test.single {
+			t.Errorf("%s: single male cell is %v, want %v", test.mode, v, test.single)
+		}
+		if v := ct.multiwayTable.At(couple.U, couple.V, 2, 1); v != test.couple {
+			t.Errorf("%s: couple multiway cell is %v, want %v", test.mode, v, test.couple)
+		}
+		if v := ct.workHouseholdTable.At(0, 4); v != test.livingIn {
+			t.Errorf("%s: living in cell is %v, want %v", test.mode, v, test.livingIn)
+		}
+		if v := ct.workHouseholdTable.At(3, 0); v != test.singleFem {
+			t.Errorf("%s: single female cell is %v, want %v", test.mode, v, test.singleFem)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package synth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ValidationErrors contains all problems found while validating the
+// parameters of a synthesis run.
+type ValidationErrors []error
+
+// Error implements the error interface by listing every problem on its own line.
+func (v ValidationErrors) Error() string {
+	lines := make([]string, len(v))
+	for i, err := range v {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problem(s) with synthesis parameters:\n\t%s", len(v), strings.Join(lines, "\n\t"))
+}
+
+// Validate checks the parameters before any work is started. It checks the
+// independent variables, the existence and the columns of the input files and
+// the ipf parameters. All problems are returned at once as ValidationErrors.
+func (args *SynthesizePopulationParams) Validate() error {
+	var errs ValidationErrors
+
+	seen := make(map[string]bool)
+	for _, name := range args.IndependentVars {
+		if _, exists := IndepVarLevels[name]; !exists {
+			errs = append(errs, fmt.Errorf("independent var with name %q is not defined", name))
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("independent var with name %q is used more than once", name))
+		}
+		seen[name] = true
+	}
+
+	if len(args.DayDistribution) > 0 {
+		if hasVar(args.IndependentVars, "Day") {
+			errs = append(errs, fmt.Errorf("a day distribution is given while Day is also an independent var"))
+		}
+		errs = append(errs, checkDistribution("day", args.DayDistribution, IndepVarLevels["Day"])...)
+	}
+
+	switch source := args.SeedSource.(type) {
+	case nil:
+		errs = append(errs, checkFile("mon data", args.MonDataFilename)...)
+	case *MonSeedSource:
+		columns := []string{source.IDColumn}
+		for _, c := range []string{source.WeightColumn, source.PersonWeightColumn} {
+			if c != "" {
+				columns = append(columns, c)
+			}
+		}
+		if len(columns) == 1 {
+			errs = append(errs, checkFile("mon data", source.Filename)...)
+		} else {
+			errs = append(errs, checkColumns("mon data", source.Filename, detectComma(source.Filename), columns)...)
+		}
+	case *CsvSeedSource:
+		errs = append(errs, source.validate()...)
+	}
+	errs = append(errs, checkFile("locsnl", args.LocsNLFilename)...)
+	schema := DefaultControlSchema
+	if args.ControlSchemaFilename != "" {
+		if fileErrs := checkFile("control schema", args.ControlSchemaFilename); fileErrs != nil {
+			errs = append(errs, fileErrs...)
+		} else if f, err := os.Open(args.ControlSchemaFilename); err != nil {
+			errs = append(errs, fmt.Errorf("control schema file: %v", err))
+		} else {
+			schema, err = ParseControlSchema(f)
+			f.Close()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("control schema file %s: %v", args.ControlSchemaFilename, err))
+			}
+		}
+	}
+	if schema != nil { // A schema that could not be read is already reported
+		if schemaErrs := schema.validate(); schemaErrs != nil {
+			errs = append(errs, schemaErrs...)
+		} else {
+			errs = append(errs, checkColumns("subzones", args.SubZonesFilename, '\t', schema.columns())...)
+		}
+	}
+	errs = append(errs, checkColumns("zipcodes", args.ZipCodesFilename, '\t', requiredColumns(ZipCodeRecord{}))...)
+	if args.SpatialSegmentsFilename != "" {
+		errs = append(errs, checkColumns("spatial segments", args.SpatialSegmentsFilename, '\t', requiredColumns(SpatialSegmentRule{}))...)
+	}
+	if args.PersonControlsFilename != "" {
+		errs = append(errs, checkColumns("person controls", args.PersonControlsFilename, '\t', requiredColumns(PersonControls{}))...)
+	}
+
+	if args.SynthesizeMembers && args.Method != MethodSample && !hasVar(args.IndependentVars, "Child") {
+		errs = append(errs, fmt.Errorf("members are synthesized while Child is not an independent var, so they cannot be drawn by child category"))
+	}
+
+	switch args.Method {
+	case "", MethodIpf, MethodSample:
+	default:
+		errs = append(errs, fmt.Errorf("unknown synthesis method %q", args.Method))
+	}
+
+	switch args.Integerisation {
+	case "", IntegeriseInvariant, IntegeriseTRS, IntegeriseControlled, IntegeriseStochastic:
+	default:
+		errs = append(errs, fmt.Errorf("unknown integerisation method %q", args.Integerisation))
+	}
+
+	for table, mode := range args.SeedCounting {
+		if !hasVar(seedTables, table) {
+			errs = append(errs, fmt.Errorf("unknown seed table %q for counting mode", table))
+		}
+		if mode != CountHouseholds && mode != CountPersons {
+			errs = append(errs, fmt.Errorf("unknown counting mode %q for seed table %s", mode, table))
+		}
+	}
+
+	switch args.SeedSmoothing.Method {
+	case "", SmoothNone:
+	case SmoothPool:
+		if math.IsNaN(args.SeedSmoothing.Weight) || args.SeedSmoothing.Weight < 0 || args.SeedSmoothing.Weight > 1 {
+			errs = append(errs, fmt.Errorf("pool smoothing weight must be between 0 and 1, got %f", args.SeedSmoothing.Weight))
+		}
+	case SmoothPrior:
+		if math.IsNaN(args.SeedSmoothing.Weight) || args.SeedSmoothing.Weight <= 0 {
+			errs = append(errs, fmt.Errorf("prior smoothing weight must be positive, got %f", args.SeedSmoothing.Weight))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown seed smoothing method %q", args.SeedSmoothing.Method))
+	}
+
+	if math.IsNaN(args.MinCoverage) || args.MinCoverage < 0 || args.MinCoverage > 1 {
+		errs = append(errs, fmt.Errorf("minimal coverage must be between 0 and 1, got %f", args.MinCoverage))
+	}
+
+	if math.IsNaN(args.IpfParams.ConvLevel) || args.IpfParams.ConvLevel <= 0 {
+		errs = append(errs, fmt.Errorf("ipf convergence level must be positive, got %f", args.IpfParams.ConvLevel))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkDistribution checks that a distribution has the given number of
+// categories, contains no negative weights and does not sum to zero.
+func checkDistribution(description string, weights []float64, levels int) (errs []error) {
+	if len(weights) != levels {
+		errs = append(errs, fmt.Errorf("%s distribution must have %d values, got %d", description, levels, len(weights)))
+	}
+	total := 0.0
+	for i, w := range weights {
+		if math.IsNaN(w) || w < 0 {
+			errs = append(errs, fmt.Errorf("%s distribution has an invalid weight %f at position %d", description, w, i))
+		}
+		total += w
+	}
+	if total <= 0 {
+		errs = append(errs, fmt.Errorf("%s distribution sums to zero", description))
+	}
+	return
+}
+
+// checkFile checks that filename is given and is a readable regular file.
+func checkFile(description, filename string) (errs []error) {
+	if filename == "" {
+		return []error{fmt.Errorf("no %s file given", description)}
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return []error{fmt.Errorf("%s file: %v", description, err)}
+	}
+	if info.IsDir() {
+		return []error{fmt.Errorf("%s file %s is a directory", description, filename)}
+	}
+	return nil
+}
+
+// checkColumns checks that the header of the file contains all the given columns.
+// Column names are matched like the readers do, see columnIndex.
+func checkColumns(description, filename string, sep rune, columns []string) (errs []error) {
+	if errs = checkFile(description, filename); errs != nil {
+		return
+	}
+
+	header, err := readHeader(filename, sep)
+	if err != nil {
+		return []error{fmt.Errorf("%s file %s: %v", description, filename, err)}
+	}
+
+	for _, c := range columns {
+		if columnIndex(header, c) == -1 {
+			errs = append(errs, fmt.Errorf("%s file %s is missing column %q", description, filename, c))
+		}
+	}
+	return
+}
+
+// readHeader returns the first record of a delimited file, which may be compressed.
+func readHeader(filename string, sep rune) ([]string, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = sep
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	return r.Read()
+}
+
+// requiredColumns returns the column names the csv reader uses for the fields
+// of the given struct: the csv tag if there is one, otherwise the field name.
+// Fields tagged optional are not required.
+func requiredColumns(v interface{}) (columns []string) {
+	t := reflect.TypeOf(v)
+	seen := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("csv"), ",")
+		if len(tag) > 1 && tag[1] == "optional" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		if !seen[name] {
+			seen[name] = true
+			columns = append(columns, name)
+		}
+	}
+	return
+}
@@ -0,0 +1,29 @@
+package synth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckColumns(t *testing.T) {
+	tests := []struct {
+		header  string
+		missing int
+	}{
+		{"Subzone\tPpc\tHh\tFev\tPhev", 0},
+		{"subzone\tppc\thh\tfev\tphev", 0}, // Like sample.txt, read by tools.CsvReader
+		{" SUBZONE \tPPC\tHH\tFEV\tPHEV", 0},
+		{"subzone\tppc\thouseholds\tfev", 2},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "zipcodes.txt")
+		if err := os.WriteFile(filename, []byte(test.header+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if errs := checkColumns("zipcodes", filename, '\t', requiredColumns(ZipCodeRecord{})); len(errs) != test.missing {
+			t.Errorf("header %q: got errors %v, want %d missing columns", test.header, errs, test.missing)
+		}
+	}
+}
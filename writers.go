@@ -0,0 +1,258 @@
+package synth
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bitbucket.org/SeheonKim/albatros4/model"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Writer writes a synthetic population in a specific format
+type Writer interface {
+	// Write writes the households to out and returns the number of households written.
+	// It reads all households from the channel, also when an error occurs.
+	Write(out io.Writer, hhs <-chan *model.Household) (int, error)
+}
+
+// CsvWriter writes the households with the columns of WriteCsv separated by Comma
+type CsvWriter struct {
+	Comma rune
+}
+
+// Write implements Writer
+func (w *CsvWriter) Write(out io.Writer, hhs <-chan *model.Household) (int, error) {
+	return writeRows(out, w.Comma, csvHeader, hhs, csvRecord)
+}
+
+// JSONLinesWriter writes every household with all its members as a JSON object on a single line
+type JSONLinesWriter struct{}
+
+// jsonHousehold is the JSON representation of a household
+type jsonHousehold struct {
+	Hhid    int
+	Home    model.Location
+	Gem     int
+	Prov    int
+	Urb     model.Urb
+	Comp    model.Comp
+	Child   model.Child
+	Day     model.Day
+	SEC     model.Sec
+	Ncar    int8
+	Driver  int
+	EV      bool
+	FEV     bool
+	PHEV    bool
+	Members []jsonPerson
+}
+
+// jsonPerson is the JSON representation of a household member
+type jsonPerson struct {
+	ID       int
+	Head     bool
+	Age      model.Age
+	Gender   model.Gender
+	Work     model.Work
+	IsDriver bool
+}
+
+// Write implements Writer
+func (w *JSONLinesWriter) Write(out io.Writer, hhs <-chan *model.Household) (rows int, err error) {
+	buf := bufio.NewWriter(out)
+	enc := json.NewEncoder(buf)
+	for hh := range hhs {
+		j := jsonHousehold{
+			Hhid:    hh.ID,
+			Home:    hh.Home,
+			Gem:     hh.WoGem,
+			Prov:    hh.Prov,
+			Urb:     hh.Urb,
+			Comp:    hh.Comp,
+			Child:   hh.Child,
+			Day:     hh.Day,
+			SEC:     hh.Sec,
+			Ncar:    hh.NumCars,
+			Driver:  hh.Driver,
+			EV:      hh.EV,
+			FEV:     hh.FEV,
+			PHEV:    hh.PHEV,
+			Members: make([]jsonPerson, 0, len(hh.Member)),
+		}
+		for _, mem := range hh.Member {
+			j.Members = append(j.Members, jsonPerson{mem.ID, mem.Head, mem.Age, mem.Gender, mem.Work, mem.IsDriver})
+		}
+
+		if err = enc.Encode(j); err != nil {
+			drain(hhs)
+			return
+		}
+		rows++
+	}
+
+	err = buf.Flush()
+	return
+}
+
+var (
+	// CsvFormat writes comma separated files like WriteCsv
+	CsvFormat Writer = &CsvWriter{','}
+	// TsvFormat writes tab separated files like the input files of ReadSubzones
+	TsvFormat Writer = &CsvWriter{'\t'}
+	// JSONLinesFormat writes a JSON object per household
+	JSONLinesFormat Writer = &JSONLinesWriter{}
+	// BinaryFormat writes the compact columnar format read by ReadSynthBinaryFile
+	BinaryFormat Writer = &BinaryWriter{}
+)
+
+// formats maps the file extensions to their Writer
+var formats = map[string]Writer{
+	".csv":    CsvFormat,
+	".tsv":    TsvFormat,
+	".txt":    TsvFormat,
+	".jsonl":  JSONLinesFormat,
+	".ndjson": JSONLinesFormat,
+	".synb":   BinaryFormat,
+}
+
+// compression wraps the files with a compression extension
+type compression struct {
+	compress   func(io.Writer) (io.WriteCloser, error)
+	decompress func(io.Reader) (io.ReadCloser, error)
+}
+
+// compressions maps the extensions of compressed files to their compression
+var compressions = map[string]compression{
+	".gz": {
+		func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	".zst": {
+		func(w io.Writer) (io.WriteCloser, error) {
+			enc, err := zstd.NewWriter(w)
+			if err != nil {
+				return nil, err
+			}
+			return enc, nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+}
+
+// compressedFile closes the compressor before the underlying file
+type compressedFile struct {
+	io.WriteCloser
+	f *os.File
+}
+
+// Close implements io.Closer
+func (c *compressedFile) Close() error {
+	err := c.WriteCloser.Close()
+	if ferr := c.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// splitCompression returns the filename without its compression extension and that extension
+func splitCompression(filename string) (string, string) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if _, exists := compressions[ext]; exists {
+		return filename[:len(filename)-len(ext)], ext
+	}
+	return filename, ""
+}
+
+// createFile creates filename. When the filename ends in a compression extension,
+// like .gz or .zst, everything written is compressed.
+func createFile(filename string) (io.WriteCloser, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	_, ext := splitCompression(filename)
+	if ext == "" {
+		return f, nil
+	}
+
+	w, err := compressions[ext].compress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &compressedFile{w, f}, nil
+}
+
+// decompressedFile closes the decompressor before the underlying file
+type decompressedFile struct {
+	io.ReadCloser
+	f *os.File
+}
+
+// Close implements io.Closer
+func (d *decompressedFile) Close() error {
+	err := d.ReadCloser.Close()
+	if ferr := d.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// openFile opens filename. When the filename ends in a compression extension, like .gz
+// or .zst, everything read is decompressed.
+func openFile(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	_, ext := splitCompression(filename)
+	if ext == "" {
+		return f, nil
+	}
+
+	r, err := compressions[ext].decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decompressedFile{r, f}, nil
+}
+
+// WriterFor returns the Writer for the extension of filename, ignoring a compression
+// extension. For example population.tsv.gz is written by TsvFormat.
+func WriterFor(filename string) (Writer, error) {
+	name, _ := splitCompression(filename)
+	ext := strings.ToLower(filepath.Ext(name))
+	w, exists := formats[ext]
+	if !exists {
+		return nil, fmt.Errorf("unknown output format %q for file %s", ext, filename)
+	}
+	return w, nil
+}
+
+// WritePopulationFile writes the households to filename with the Writer and compression
+// selected by the extension of filename. It returns the number of households written.
+func WritePopulationFile(filename string, hhs <-chan *model.Household) (int, error) {
+	w, err := WriterFor(filename)
+	if err != nil {
+		drain(hhs)
+		return 0, err
+	}
+	return writeFile(filename, hhs, w.Write)
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 
 	"bitbucket.org/SeheonKim/albatros4/model"
@@ -15,6 +16,7 @@ import (
 type ZipCodeCount struct {
 	ZipCodeCount string
 	Count        int
+	Weight       int // The count as added, which defines the distribution
 }
 
 // ZipCodeGenerator is used to randomly generate zipcodes given the same
@@ -23,48 +25,115 @@ type ZipCodeGenerator struct {
 	Subzone       int
 	ZipCodeCounts []ZipCodeCount
 	Total         int
+	exhausted     bool
 }
 
 // ZipCodePerSubzone contains a ZipCodeGenerator for each subzone based on subzone id.
 type ZipCodePerSubzone map[int]*ZipCodeGenerator
 
-// Add adds a zipcode and the number of zipcodes there are to a generator
+// Add adds a zipcode and the number of zipcodes there are to a generator.
+// A negative count is added as 0.
 func (z *ZipCodeGenerator) Add(zipcode string, count int) {
-	z.ZipCodeCounts = append(z.ZipCodeCounts, ZipCodeCount{zipcode, count})
+	if count < 0 {
+		log.Printf("Negative count %d for zipcode %s in subzone %d is set to 0", count, zipcode, z.Subzone)
+		count = 0
+	}
+	z.ZipCodeCounts = append(z.ZipCodeCounts, ZipCodeCount{zipcode, count, count})
 	z.Total += count
 }
 
+// weights returns the weight of every zipcode. When all weights are 0 every
+// zipcode has weight 1, such that the zipcodes are uniformly distributed.
+func (z *ZipCodeGenerator) weights() []int {
+	weights := make([]int, len(z.ZipCodeCounts))
+	s := 0
+	for i, c := range z.ZipCodeCounts {
+		weights[i] = c.Weight
+		s += c.Weight
+	}
+	if s == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return weights
+}
+
 // SetTotal will set the new total for the generator. It will change the counts of the individual
-// ZipCodes but keep the same distribution.
+// ZipCodes but keep the same distribution. The counts are the integer parts of the shares of the
+// total, after which the remainder is given one by one to the zipcodes with the largest fractions.
+// The counts are never negative and sum to the total.
 func (z *ZipCodeGenerator) SetTotal(total int) {
+	z.exhausted = false
 	if len(z.ZipCodeCounts) == 0 {
 		log.Printf("Setting total on zipcode generator not containing any zipcodes for subzone %d", z.Subzone)
 		z.Total = 0
 		return
 	}
+	if total < 0 {
+		log.Printf("Setting negative total %d on zipcode generator for subzone %d, using 0", total, z.Subzone)
+		total = 0
+	}
 
+	weights := z.weights()
 	s := 0
-	for _, z := range z.ZipCodeCounts {
-		s += z.Count
+	for _, w := range weights {
+		s += w
 	}
 
 	t := 0
-	for i := range z.ZipCodeCounts[:len(z.ZipCodeCounts)-1] {
-		z.ZipCodeCounts[i].Count = (z.ZipCodeCounts[i].Count * total) / s
+	remainders := make([]int, len(weights))
+	for i, w := range weights {
+		z.ZipCodeCounts[i].Count = w * total / s
+		remainders[i] = w * total % s
 		t += z.ZipCodeCounts[i].Count
 	}
 
-	z.ZipCodeCounts[len(z.ZipCodeCounts)-1].Count = total - t
+	// Largest remainders first, ties in file order
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for _, i := range order[:total-t] {
+		z.ZipCodeCounts[i].Count++
+	}
+
 	z.Total = total
 }
 
 // GetRandomZipcode generate a random ZipCode. It will also
 // decrease the count of that zipcode and the total count.
+// When all zipcodes are used, it draws from the distribution the zipcodes were
+// added with without decreasing any count. It only returns "" when there are no zipcodes.
 func (z *ZipCodeGenerator) GetRandomZipcode() string {
-	if z.Total == 0 {
+	if len(z.ZipCodeCounts) == 0 {
 		return ""
 	}
 
+	if z.Total <= 0 {
+		if !z.exhausted {
+			log.Printf("All zipcodes of subzone %d are used, drawing more from the zipcode distribution", z.Subzone)
+			z.exhausted = true
+		}
+
+		weights := z.weights()
+		s := 0
+		for _, w := range weights {
+			s += w
+		}
+		r := rand.Int() % s
+		t := 0
+		for i, w := range weights {
+			t += w
+			if r < t {
+				return z.ZipCodeCounts[i].ZipCodeCount
+			}
+		}
+	}
+
 	r := rand.Int() % z.Total
 	t := 0
 	for i := range z.ZipCodeCounts {
@@ -121,8 +190,8 @@ type ZipCode struct {
 	Ppc map[model.Location]*ZipCodeInfo
 }
 type ZipCodeInfo struct {
-	Ppc model.Location // The zipcode
-	HH  int
+	Ppc  model.Location // The zipcode
+	HH   int
 	Fev  int
 	Phev int
 }
@@ -0,0 +1,72 @@
+package synth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZipCodeGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		zipcodes []string
+		counts   []int
+		total    int
+		want     []int           // Counts after SetTotal
+		fallback map[string]bool // Zipcodes drawn once all are used
+	}{
+		{"single zipcode", []string{"1000"}, []int{5}, 3, []int{3}, map[string]bool{"1000": true}},
+		{"zero total", []string{"1000", "2000"}, []int{2, 3}, 0, []int{0, 0}, map[string]bool{"1000": true, "2000": true}},
+		{"all zero weights", []string{"1000", "2000", "3000"}, []int{0, 0, 0}, 7, []int{3, 2, 2}, map[string]bool{"1000": true, "2000": true, "3000": true}},
+		{"negative count", []string{"1000", "2000"}, []int{-4, 6}, 5, []int{0, 5}, map[string]bool{"2000": true}},
+		{"largest remainder", []string{"1000", "2000", "3000"}, []int{5, 3, 2}, 7, []int{4, 2, 1}, map[string]bool{"1000": true, "2000": true, "3000": true}},
+		{"equal shares", []string{"1000", "2000", "3000"}, []int{1, 1, 1}, 10, []int{4, 3, 3}, map[string]bool{"1000": true, "2000": true, "3000": true}},
+		{"exhausted zero weight", []string{"1000", "2000"}, []int{0, 4}, 2, []int{0, 2}, map[string]bool{"2000": true}},
+	}
+
+	for _, test := range tests {
+		z := &ZipCodeGenerator{Subzone: 1}
+		for i, zipcode := range test.zipcodes {
+			z.Add(zipcode, test.counts[i])
+		}
+		z.SetTotal(test.total)
+
+		counts := make([]int, len(z.ZipCodeCounts))
+		sum := 0
+		for i, c := range z.ZipCodeCounts {
+			counts[i] = c.Count
+			sum += c.Count
+			if c.Count < 0 {
+				t.Errorf("%s: zipcode %s has negative count %d", test.name, c.ZipCodeCount, c.Count)
+			}
+		}
+		if sum != test.total || z.Total != test.total {
+			t.Errorf("%s: counts sum to %d with total %d, want %d", test.name, sum, z.Total, test.total)
+		}
+		if !reflect.DeepEqual(counts, test.want) {
+			t.Errorf("%s: counts are %v, want %v", test.name, counts, test.want)
+		}
+
+		// Drawing the total uses every zipcode its count times
+		drawn := make(map[string]int)
+		for i := 0; i < test.total; i++ {
+			drawn[z.GetRandomZipcode()]++
+		}
+		for i, zipcode := range test.zipcodes {
+			if drawn[zipcode] != test.want[i] {
+				t.Errorf("%s: zipcode %s drawn %d times, want %d", test.name, zipcode, drawn[zipcode], test.want[i])
+			}
+		}
+
+		// After that the zipcodes are drawn from the distribution they were added with
+		for i := 0; i < 50; i++ {
+			if zipcode := z.GetRandomZipcode(); !test.fallback[zipcode] {
+				t.Errorf("%s: drew zipcode %q after all were used, want one of %v", test.name, zipcode, test.fallback)
+				break
+			}
+		}
+	}
+
+	if zipcode := new(ZipCodeGenerator).GetRandomZipcode(); zipcode != "" {
+		t.Errorf("generator without zipcodes drew %q, want none", zipcode)
+	}
+}